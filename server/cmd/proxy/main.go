@@ -8,6 +8,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/ihaxolotl/webproxy/internal/api"
 	"github.com/ihaxolotl/webproxy/internal/data"
+	"github.com/ihaxolotl/webproxy/internal/proxy"
 )
 
 const APIAddr = ":8888"
@@ -21,7 +22,10 @@ func main() {
 	m := mux.NewRouter()
 	m.StrictSlash(true)
 
-	ctx := api.Context{Database: db}
+	ctx := api.Context{
+		Database: db,
+		Proxies:  proxy.NewManager(db),
+	}
 
 	for _, rt := range api.APIRoutes {
 		m.Path(rt.URL).
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ihaxolotl/webproxy/internal/data/chain"
+)
+
+// CreateChainRuleRoute is an endpoint for adding an upstream proxy rule to a
+// project. New rules are enabled unless the request body says otherwise.
+func CreateChainRuleRoute(ctx Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			vars      map[string]string
+			projectId string
+			rule      chain.Rule
+			err       error
+		)
+
+		vars = mux.Vars(r)
+		projectId = vars["projectId"]
+
+		if _, err = ctx.Database.Projects.FetchById(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": err.Error()})
+			return
+		}
+
+		rule = chain.Rule{Enabled: true}
+
+		if err = json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			ctx.JSON(&rw, http.StatusUnprocessableEntity, JSON{"err": err.Error()})
+			return
+		}
+
+		rule.ProjectID = projectId
+
+		if err = rule.Validate(); err != nil {
+			ctx.JSON(&rw, http.StatusUnprocessableEntity, JSON{"err": err.Error()})
+			return
+		}
+
+		if err = ctx.Database.Chain.Insert(&rule); err != nil {
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		if err = ctx.reloadProxy(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		ctx.JSON(&rw, http.StatusCreated, JSON{
+			"msg":  "Rule successfully created",
+			"rule": rule,
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ihaxolotl/webproxy/internal/data/intercept"
+)
+
+// CreateInterceptRuleRoute is an endpoint for adding an intercept rule to a
+// project. New rules are enabled, combined with AND, and evaluated after the
+// existing rules unless the request body says otherwise.
+func CreateInterceptRuleRoute(ctx Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			vars      map[string]string
+			projectId string
+			rule      intercept.Rule
+			err       error
+		)
+
+		vars = mux.Vars(r)
+		projectId = vars["projectId"]
+
+		if _, err = ctx.Database.Projects.FetchById(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": err.Error()})
+			return
+		}
+
+		rule = intercept.Rule{
+			Enabled:  true,
+			Operator: intercept.OperatorAnd,
+		}
+
+		if err = json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			ctx.JSON(&rw, http.StatusUnprocessableEntity, JSON{"err": err.Error()})
+			return
+		}
+
+		rule.ProjectID = projectId
+
+		if err = rule.Validate(); err != nil {
+			ctx.JSON(&rw, http.StatusUnprocessableEntity, JSON{"err": err.Error()})
+			return
+		}
+
+		if err = ctx.Database.Intercept.Insert(&rule); err != nil {
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		if err = ctx.reloadProxy(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		ctx.JSON(&rw, http.StatusCreated, JSON{
+			"msg":  "Rule successfully created",
+			"rule": rule,
+		})
+	}
+}
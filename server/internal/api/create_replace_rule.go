@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ihaxolotl/webproxy/internal/data/replace"
+)
+
+// CreateReplaceRuleRoute is an endpoint for adding a match/replace rule to a
+// project, which rewrites the requests or responses passing through its proxy.
+// New rules are enabled and applied after the existing rules unless the request
+// body says otherwise. A regular expression that doesn't compile is rejected.
+func CreateReplaceRuleRoute(ctx Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			vars      map[string]string
+			projectId string
+			rule      replace.Rule
+			err       error
+		)
+
+		vars = mux.Vars(r)
+		projectId = vars["projectId"]
+
+		if _, err = ctx.Database.Projects.FetchById(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": err.Error()})
+			return
+		}
+
+		rule = replace.Rule{Enabled: true}
+
+		if err = json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			ctx.JSON(&rw, http.StatusUnprocessableEntity, JSON{"err": err.Error()})
+			return
+		}
+
+		rule.ProjectID = projectId
+
+		if err = rule.Validate(); err != nil {
+			ctx.JSON(&rw, http.StatusUnprocessableEntity, JSON{"err": err.Error()})
+			return
+		}
+
+		if err = ctx.Database.Replace.Insert(&rule); err != nil {
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		if err = ctx.reloadProxy(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		ctx.JSON(&rw, http.StatusCreated, JSON{
+			"msg":  "Rule successfully created",
+			"rule": rule,
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ihaxolotl/webproxy/internal/data/scope"
+)
+
+// CreateScopeRuleRoute is an endpoint for adding a rule that includes targets in
+// a project's scope, or excludes them from it. New rules are enabled unless the
+// request body says otherwise. Hosts are matched literally unless the rule is
+// flagged as a regular expression.
+func CreateScopeRuleRoute(ctx Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			vars      map[string]string
+			projectId string
+			rule      scope.Rule
+			err       error
+		)
+
+		vars = mux.Vars(r)
+		projectId = vars["projectId"]
+
+		if _, err = ctx.Database.Projects.FetchById(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": err.Error()})
+			return
+		}
+
+		rule = scope.Rule{Enabled: true}
+
+		if err = json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			ctx.JSON(&rw, http.StatusUnprocessableEntity, JSON{"err": err.Error()})
+			return
+		}
+
+		rule.ProjectID = projectId
+
+		if err = rule.Validate(); err != nil {
+			ctx.JSON(&rw, http.StatusUnprocessableEntity, JSON{"err": err.Error()})
+			return
+		}
+
+		if err = ctx.Database.Scope.Insert(&rule); err != nil {
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		if err = ctx.reloadProxy(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		ctx.JSON(&rw, http.StatusCreated, JSON{
+			"msg":  "Rule successfully created",
+			"rule": rule,
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ihaxolotl/webproxy/internal/data/scope"
+)
+
+// DeleteScopeRuleRoute is an endpoint for removing a scope rule from a project.
+// Removing the last include rule puts every target in scope.
+func DeleteScopeRuleRoute(ctx Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			vars      map[string]string
+			projectId string
+			ruleId    string
+			rule      *scope.Rule
+			err       error
+		)
+
+		vars = mux.Vars(r)
+		projectId = vars["projectId"]
+		ruleId = vars["ruleId"]
+
+		if rule, err = ctx.Database.Scope.FetchById(ruleId); err != nil || rule.ProjectID != projectId {
+			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": "rule not found"})
+			return
+		}
+
+		if err = ctx.Database.Scope.Delete(ruleId); err != nil {
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		if err = ctx.reloadProxy(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		ctx.JSON(&rw, http.StatusOK, JSON{"msg": "Rule successfully deleted"})
+	}
+}
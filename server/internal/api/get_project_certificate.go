@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ihaxolotl/webproxy/internal/certs"
+)
+
+// writeCertificate writes an encoded root certificate as a file download.
+func writeCertificate(rw http.ResponseWriter, contentType string, filename string, cert []byte) {
+	rw.Header().Set("Content-Type", contentType)
+	rw.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(cert)
+}
+
+// GetProjectCertificatePEMRoute is an endpoint for downloading the root certificate
+// of a project in PEM format. The certificate can be installed on test devices so
+// that they trust the proxy's intercepted TLS connections.
+func GetProjectCertificatePEMRoute(ctx Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			vars      map[string]string
+			projectId string
+			ca        *certs.Authority
+			err       error
+		)
+
+		vars = mux.Vars(r)
+		projectId = vars["projectId"]
+
+		if ca, err = ctx.Database.Projects.FetchAuthority(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": err.Error()})
+			return
+		}
+
+		writeCertificate(rw, "application/x-pem-file", "webproxy-ca.pem", ca.CertificatePEM())
+	}
+}
+
+// GetProjectCertificateDERRoute is an endpoint for downloading the root certificate
+// of a project in DER format.
+func GetProjectCertificateDERRoute(ctx Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			vars      map[string]string
+			projectId string
+			ca        *certs.Authority
+			err       error
+		)
+
+		vars = mux.Vars(r)
+		projectId = vars["projectId"]
+
+		if ca, err = ctx.Database.Projects.FetchAuthority(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": err.Error()})
+			return
+		}
+
+		writeCertificate(rw, "application/x-x509-ca-cert", "webproxy-ca.der", ca.DER())
+	}
+}
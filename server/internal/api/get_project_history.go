@@ -2,15 +2,20 @@ package api
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/ihaxolotl/webproxy/internal/data/history"
 )
 
+// GetProjectHistoryRoute is an endpoint for fetching the history of requests made
+// through a project's proxy. With the inScope=true query parameter, only requests
+// in the project's scope are returned.
 func GetProjectHistoryRoute(ctx Context) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		var (
 			hist      []history.HistoryEntry
+			filter    history.Filter
 			vars      map[string]string
 			projectId string
 			err       error
@@ -19,7 +24,14 @@ func GetProjectHistoryRoute(ctx Context) http.HandlerFunc {
 		vars = mux.Vars(r)
 		projectId = vars["projectId"]
 
-		if hist, err = ctx.Database.History.Fetch(projectId); err != nil {
+		if inScope := r.URL.Query().Get("inScope"); inScope != "" {
+			if filter.InScope, err = strconv.ParseBool(inScope); err != nil {
+				ctx.JSON(&rw, http.StatusBadRequest, JSON{"err": "inScope must be true or false"})
+				return
+			}
+		}
+
+		if hist, err = ctx.Database.History.Fetch(projectId, filter); err != nil {
 			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": err.Error()})
 			return
 		}
@@ -4,13 +4,17 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/ihaxolotl/webproxy/internal/proxy"
 )
 
-func HandleProxy(ctx Context, conn *websocket.Conn, cmd chan proxy.ProxyCmd) error {
+// HandleProxy reads commands from a control panel's WebSocket connection and
+// sends them to the proxy it is attached to. Commands that are invalid or refer
+// to items that are no longer stalled are answered with an error.
+func HandleProxy(ctx Context, conn *websocket.Conn, prox *proxy.Proxy, client *proxy.Client) error {
 	for {
 		var (
 			raw []byte
@@ -27,11 +31,19 @@ func HandleProxy(ctx Context, conn *websocket.Conn, cmd chan proxy.ProxyCmd) err
 			return err
 		}
 
-		if err = msg.Validate(); err != nil {
-			return err
+		if err = prox.Validate(&msg); err == nil {
+			err = prox.Command(client, msg)
 		}
 
-		cmd <- msg
+		if err != nil {
+			if err = client.Send(&proxy.ProxyCmd{
+				Type: proxy.ProxyCmdError,
+				ID:   msg.ID,
+				Data: err.Error(),
+			}); err != nil {
+				return err
+			}
+		}
 	}
 }
 
@@ -39,18 +51,19 @@ func HandleProxy(ctx Context, conn *websocket.Conn, cmd chan proxy.ProxyCmd) err
 var upgrader = websocket.Upgrader{}
 
 // GetProjectProxyRoute is an endpoint for connecting to the intercept proxy
-// for the project. The endpoint will first check if the projectId passed as a
-// URL variable corresponds to an existing project in the database. If the project
-// exists, the endpoint will upgrade the connection to a WebSocket and will now
-// receive messages from the client to control the proxy.
+// for the project. The endpoint will first check if the proxy for the projectId
+// passed as a URL variable is running. If it is, the endpoint will upgrade the
+// connection to a WebSocket, attach it to the proxy, and will now receive
+// messages from the client to control the proxy. The proxy keeps running once
+// the WebSocket is closed.
 func GetProjectProxyRoute(ctx Context) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		var (
 			conn      *websocket.Conn
 			projectId string
 			vars      map[string]string
-			cmd       chan proxy.ProxyCmd
 			prox      *proxy.Proxy
+			client    *proxy.Client
 			err       error
 		)
 
@@ -62,19 +75,25 @@ func GetProjectProxyRoute(ctx Context) http.HandlerFunc {
 			return
 		}
 
+		if prox, err = ctx.Proxies.Get(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusConflict, JSON{"err": err.Error()})
+			return
+		}
+
 		if conn, err = upgrader.Upgrade(rw, r, nil); err != nil {
 			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
 			return
 		}
 		defer conn.Close()
 
-		cmd = make(chan proxy.ProxyCmd)
+		// The API server's timeouts don't apply to the WebSocket, which
+		// stays open for as long as the control panel is.
+		conn.UnderlyingConn().SetDeadline(time.Time{})
 
-		// Spawn a new intercept proxy
-		prox = proxy.New(projectId, ctx.Database, conn, cmd)
-		go prox.Spawn()
+		client = prox.Attach(conn)
+		defer prox.Detach(client)
 
-		if err = HandleProxy(ctx, conn, cmd); err != nil {
+		if err = HandleProxy(ctx, conn, prox, client); err != nil {
 			log.Println(err)
 			conn.WriteMessage(websocket.CloseMessage, []byte(err.Error()))
 		}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ihaxolotl/webproxy/internal/data/requests"
+	"github.com/ihaxolotl/webproxy/internal/diff"
+	"github.com/ihaxolotl/webproxy/internal/proxy"
+)
+
+// Diff modes accepted by the diff endpoints.
+const (
+	DiffModeLine = "line"
+	DiffModeByte = "byte"
+)
+
+// diffEdit is an edit of a diff as it is sent to the client. Data that isn't
+// valid UTF-8 is base64-encoded.
+type diffEdit struct {
+	Op       diff.Op `json:"op"`
+	Old      int     `json:"old"`
+	New      int     `json:"new"`
+	Data     string  `json:"data"`
+	Encoding string  `json:"encoding"`
+}
+
+// writeDiff sends the diff between the original and the forwarded version of
+// a message, in the mode passed as the "mode" query parameter. Lines are
+// compared by default. A message that was not edited is compared to itself.
+func writeDiff(ctx Context, rw http.ResponseWriter, r *http.Request, original, forwarded []byte) {
+	var (
+		mode   string
+		edited bool
+		edits  []diff.Edit
+		result []diffEdit
+		err    error
+	)
+
+	if edited = original != nil; !edited {
+		original = forwarded
+	}
+
+	switch mode = r.URL.Query().Get("mode"); mode {
+	case "", DiffModeLine:
+		mode = DiffModeLine
+		edits, err = diff.Lines(original, forwarded)
+	case DiffModeByte:
+		edits, err = diff.Bytes(original, forwarded)
+	default:
+		ctx.JSON(&rw, http.StatusBadRequest, JSON{"err": "invalid diff mode " + mode})
+		return
+	}
+
+	if err != nil {
+		ctx.JSON(&rw, http.StatusUnprocessableEntity, JSON{"err": err.Error()})
+		return
+	}
+
+	result = make([]diffEdit, 0, len(edits))
+	for _, e := range edits {
+		data, encoding := proxy.EncodePayload(e.Data)
+		result = append(result, diffEdit{e.Op, e.Old, e.New, data, encoding})
+	}
+
+	ctx.JSON(&rw, http.StatusOK, JSON{"mode": mode, "edited": edited, "edits": result})
+}
+
+// GetRequestDiffRoute is an endpoint that returns the differences between the
+// request received from the client and the request forwarded after it was edited
+// at the control panel. If the request does not exist, a status 404 is sent. If
+// the diff is too large to compute, a status 422 is sent.
+func GetRequestDiffRoute(ctx Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			vars      map[string]string
+			requestId string
+			req       *requests.Request
+			err       error
+		)
+
+		vars = mux.Vars(r)
+		requestId = vars["requestId"]
+
+		if req, err = ctx.Database.Requests.FetchById(requestId); err != nil {
+			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": err.Error()})
+			return
+		}
+
+		writeDiff(ctx, rw, r, req.Original, req.Raw)
+	}
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ihaxolotl/webproxy/internal/data/messages"
+)
+
+// GetRequestMessagesRoute is an endpoint for fetching the WebSocket messages
+// relayed over the connection upgraded by a request, in the order they were
+// received. If the request does not exist, a status 404 is sent.
+func GetRequestMessagesRoute(ctx Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			vars      map[string]string
+			requestId string
+			msgs      []messages.Message
+			err       error
+		)
+
+		vars = mux.Vars(r)
+		requestId = vars["requestId"]
+
+		if _, err = ctx.Database.Requests.FetchById(requestId); err != nil {
+			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": err.Error()})
+			return
+		}
+
+		if msgs, err = ctx.Database.Messages.Fetch(requestId); err != nil {
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		ctx.JSON(&rw, http.StatusOK, JSON{"messages": msgs})
+	}
+}
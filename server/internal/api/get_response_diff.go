@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ihaxolotl/webproxy/internal/data/responses"
+)
+
+// GetResponseDiffRoute is an endpoint that returns the differences between the
+// response received from the server and the response forwarded after it was
+// edited at the control panel. If the response does not exist, a status 404 is
+// sent. If the diff is too large to compute, a status 422 is sent.
+func GetResponseDiffRoute(ctx Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			vars       map[string]string
+			responseId string
+			res        *responses.Response
+			err        error
+		)
+
+		vars = mux.Vars(r)
+		responseId = vars["responseId"]
+
+		if res, err = ctx.Database.Responses.FetchById(responseId); err != nil {
+			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": err.Error()})
+			return
+		}
+
+		writeDiff(ctx, rw, r, res.Original, res.Raw)
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/ihaxolotl/webproxy/internal/data/responses"
+)
+
+// pretty returns a decoded body in a readable form. JSON bodies are indented,
+// and other bodies are returned as they are.
+func pretty(mimetype string, body []byte) []byte {
+	var buf bytes.Buffer
+
+	if mimetype != "application/json" && !strings.HasSuffix(mimetype, "+json") {
+		return body
+	}
+
+	if err := json.Indent(&buf, body, "", "  "); err != nil {
+		return body
+	}
+
+	return buf.Bytes()
+}
+
+// GetResponsePrettyRoute is an endpoint that returns the body of a response
+// with its transfer and content codings removed, served with the response's
+// mime-type. JSON bodies are indented. If the body could not be decoded when
+// the response was recorded, a status 422 is sent.
+func GetResponsePrettyRoute(ctx Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			vars       map[string]string
+			responseId string
+			res        *responses.Response
+			mimetype   string
+			err        error
+		)
+
+		vars = mux.Vars(r)
+		responseId = vars["responseId"]
+
+		if res, err = ctx.Database.Responses.FetchById(responseId); err != nil {
+			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": err.Error()})
+			return
+		}
+
+		if res.BodyError != "" {
+			ctx.JSON(&rw, http.StatusUnprocessableEntity, JSON{"err": res.BodyError})
+			return
+		}
+
+		if mimetype = res.Mimetype; mimetype == "" {
+			mimetype = "application/octet-stream"
+		}
+
+		// Keep the browser from rendering or running the captured content.
+		rw.Header().Set("Content-Type", mimetype)
+		rw.Header().Set("Content-Security-Policy", "sandbox")
+		rw.Header().Set("X-Content-Type-Options", "nosniff")
+		rw.WriteHeader(http.StatusOK)
+
+		if _, err = rw.Write(pretty(res.Mimetype, res.Body)); err != nil {
+			log.Println(err)
+		}
+	}
+}
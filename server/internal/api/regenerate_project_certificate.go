@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ihaxolotl/webproxy/internal/certs"
+)
+
+// RegenerateProjectCertificateRoute is an endpoint for replacing the root certificate
+// of a project with a newly generated one. Devices that trusted the previous certificate
+// will need the new one installed. A running proxy signs leaf certificates with the new
+// root certificate from then on.
+func RegenerateProjectCertificateRoute(ctx Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			vars      map[string]string
+			projectId string
+			ca        *certs.Authority
+			err       error
+		)
+
+		vars = mux.Vars(r)
+		projectId = vars["projectId"]
+
+		if ca, err = ctx.Database.Projects.RegenerateAuthority(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": err.Error()})
+			return
+		}
+
+		if prox, err := ctx.Proxies.Get(projectId); err == nil {
+			prox.SetAuthority(ca)
+		}
+
+		ctx.JSON(&rw, http.StatusCreated, JSON{
+			"msg":         "Certificate successfully regenerated",
+			"certificate": string(ca.CertificatePEM()),
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/ihaxolotl/webproxy/internal/data"
+	"github.com/ihaxolotl/webproxy/internal/proxy"
 )
 
 type Route struct {
@@ -19,6 +20,7 @@ type JSON map[string]interface{}
 
 type Context struct {
 	Database *data.Database
+	Proxies  *proxy.Manager
 }
 
 func (ctx *Context) JSON(rw *http.ResponseWriter, code int, payload interface{}) {
@@ -40,6 +42,17 @@ func (ctx *Context) JSON(rw *http.ResponseWriter, code int, payload interface{})
 	}
 }
 
+// reloadProxy applies a project's changed settings or rules to its proxy, if the
+// proxy is running.
+func (ctx *Context) reloadProxy(projectId string) error {
+	prox, err := ctx.Proxies.Get(projectId)
+	if err != nil {
+		return nil
+	}
+
+	return prox.Reload()
+}
+
 var APIRoutes []Route = []Route{
 	{
 		Name:    "Index",
@@ -71,6 +84,156 @@ var APIRoutes []Route = []Route{
 		Method:  http.MethodGet,
 		Handler: GetProjectProxyRoute,
 	},
+	{
+		Name:    "GetProjectProxyStatus",
+		URL:     "/projects/{projectId}/proxy/status",
+		Method:  http.MethodGet,
+		Handler: GetProjectProxyStatusRoute,
+	},
+	{
+		Name:    "StartProjectProxy",
+		URL:     "/projects/{projectId}/proxy/start",
+		Method:  http.MethodPost,
+		Handler: StartProjectProxyRoute,
+	},
+	{
+		Name:    "StopProjectProxy",
+		URL:     "/projects/{projectId}/proxy/stop",
+		Method:  http.MethodPost,
+		Handler: StopProjectProxyRoute,
+	},
+	{
+		Name:    "RestartProjectProxy",
+		URL:     "/projects/{projectId}/proxy/restart",
+		Method:  http.MethodPost,
+		Handler: RestartProjectProxyRoute,
+	},
+	{
+		Name:    "GetProjectCertificatePEM",
+		URL:     "/projects/{projectId}/certificate.pem",
+		Method:  http.MethodGet,
+		Handler: GetProjectCertificatePEMRoute,
+	},
+	{
+		Name:    "GetProjectCertificateDER",
+		URL:     "/projects/{projectId}/certificate.der",
+		Method:  http.MethodGet,
+		Handler: GetProjectCertificateDERRoute,
+	},
+	{
+		Name:    "RegenerateProjectCertificate",
+		URL:     "/projects/{projectId}/certificate",
+		Method:  http.MethodPost,
+		Handler: RegenerateProjectCertificateRoute,
+	},
+	{
+		Name:    "GetProjectSettings",
+		URL:     "/projects/{projectId}/settings",
+		Method:  http.MethodGet,
+		Handler: GetProjectSettingsRoute,
+	},
+	{
+		Name:    "UpdateProjectSettings",
+		URL:     "/projects/{projectId}/settings",
+		Method:  http.MethodPatch,
+		Handler: UpdateProjectSettingsRoute,
+	},
+	{
+		Name:    "GetInterceptRules",
+		URL:     "/projects/{projectId}/intercept/rules",
+		Method:  http.MethodGet,
+		Handler: GetInterceptRulesRoute,
+	},
+	{
+		Name:    "CreateInterceptRule",
+		URL:     "/projects/{projectId}/intercept/rules",
+		Method:  http.MethodPost,
+		Handler: CreateInterceptRuleRoute,
+	},
+	{
+		Name:    "UpdateInterceptRule",
+		URL:     "/projects/{projectId}/intercept/rules/{ruleId}",
+		Method:  http.MethodPatch,
+		Handler: UpdateInterceptRuleRoute,
+	},
+	{
+		Name:    "DeleteInterceptRule",
+		URL:     "/projects/{projectId}/intercept/rules/{ruleId}",
+		Method:  http.MethodDelete,
+		Handler: DeleteInterceptRuleRoute,
+	},
+	{
+		Name:    "GetReplaceRules",
+		URL:     "/projects/{projectId}/replace/rules",
+		Method:  http.MethodGet,
+		Handler: GetReplaceRulesRoute,
+	},
+	{
+		Name:    "CreateReplaceRule",
+		URL:     "/projects/{projectId}/replace/rules",
+		Method:  http.MethodPost,
+		Handler: CreateReplaceRuleRoute,
+	},
+	{
+		Name:    "UpdateReplaceRule",
+		URL:     "/projects/{projectId}/replace/rules/{ruleId}",
+		Method:  http.MethodPatch,
+		Handler: UpdateReplaceRuleRoute,
+	},
+	{
+		Name:    "DeleteReplaceRule",
+		URL:     "/projects/{projectId}/replace/rules/{ruleId}",
+		Method:  http.MethodDelete,
+		Handler: DeleteReplaceRuleRoute,
+	},
+	{
+		Name:    "GetScopeRules",
+		URL:     "/projects/{projectId}/scope/rules",
+		Method:  http.MethodGet,
+		Handler: GetScopeRulesRoute,
+	},
+	{
+		Name:    "CreateScopeRule",
+		URL:     "/projects/{projectId}/scope/rules",
+		Method:  http.MethodPost,
+		Handler: CreateScopeRuleRoute,
+	},
+	{
+		Name:    "UpdateScopeRule",
+		URL:     "/projects/{projectId}/scope/rules/{ruleId}",
+		Method:  http.MethodPatch,
+		Handler: UpdateScopeRuleRoute,
+	},
+	{
+		Name:    "DeleteScopeRule",
+		URL:     "/projects/{projectId}/scope/rules/{ruleId}",
+		Method:  http.MethodDelete,
+		Handler: DeleteScopeRuleRoute,
+	},
+	{
+		Name:    "GetChainRules",
+		URL:     "/projects/{projectId}/chain/rules",
+		Method:  http.MethodGet,
+		Handler: GetChainRulesRoute,
+	},
+	{
+		Name:    "CreateChainRule",
+		URL:     "/projects/{projectId}/chain/rules",
+		Method:  http.MethodPost,
+		Handler: CreateChainRuleRoute,
+	},
+	{
+		Name:    "UpdateChainRule",
+		URL:     "/projects/{projectId}/chain/rules/{ruleId}",
+		Method:  http.MethodPatch,
+		Handler: UpdateChainRuleRoute,
+	},
+	{
+		Name:    "DeleteChainRule",
+		URL:     "/projects/{projectId}/chain/rules/{ruleId}",
+		Method:  http.MethodDelete,
+		Handler: DeleteChainRuleRoute,
+	},
 	{
 		Name:    "GetProjectHistory",
 		URL:     "/projects/{projectId}/history",
@@ -83,10 +246,34 @@ var APIRoutes []Route = []Route{
 		Method:  http.MethodGet,
 		Handler: GetRequestByIdRoute,
 	},
+	{
+		Name:    "GetRequestDiff",
+		URL:     "/requests/{requestId}/diff",
+		Method:  http.MethodGet,
+		Handler: GetRequestDiffRoute,
+	},
+	{
+		Name:    "GetRequestMessages",
+		URL:     "/requests/{requestId}/messages",
+		Method:  http.MethodGet,
+		Handler: GetRequestMessagesRoute,
+	},
 	{
 		Name:    "GetResponseById",
 		URL:     "/responses/{responseId}",
 		Method:  http.MethodGet,
 		Handler: GetResponseByIdRoute,
 	},
+	{
+		Name:    "GetResponsePretty",
+		URL:     "/responses/{responseId}/pretty",
+		Method:  http.MethodGet,
+		Handler: GetResponsePrettyRoute,
+	},
+	{
+		Name:    "GetResponseDiff",
+		URL:     "/responses/{responseId}/diff",
+		Method:  http.MethodGet,
+		Handler: GetResponseDiffRoute,
+	},
 }
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ihaxolotl/webproxy/internal/proxy"
+)
+
+// StartProjectProxyRoute is an endpoint for starting the intercept proxy of a
+// project. If the proxy is already running, a status 409 is sent.
+func StartProjectProxyRoute(ctx Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			vars      map[string]string
+			projectId string
+			prox      *proxy.Proxy
+			err       error
+		)
+
+		vars = mux.Vars(r)
+		projectId = vars["projectId"]
+
+		if _, err = ctx.Database.Projects.FetchById(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": err.Error()})
+			return
+		}
+
+		if prox, err = ctx.Proxies.Start(projectId); err != nil {
+			if err == proxy.ErrProxyRunning {
+				ctx.JSON(&rw, http.StatusConflict, JSON{"err": err.Error()})
+				return
+			}
+
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		ctx.JSON(&rw, http.StatusOK, JSON{
+			"msg":    "Proxy successfully started",
+			"status": prox.Status(),
+		})
+	}
+}
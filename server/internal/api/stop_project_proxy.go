@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ihaxolotl/webproxy/internal/proxy"
+)
+
+// StopProjectProxyRoute is an endpoint for stopping the intercept proxy of a
+// project. If the project does not exist, a status 404 is sent, and if the proxy
+// is not running, a status 409 is sent.
+func StopProjectProxyRoute(ctx Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			vars      map[string]string
+			projectId string
+			err       error
+		)
+
+		vars = mux.Vars(r)
+		projectId = vars["projectId"]
+
+		if _, err = ctx.Database.Projects.FetchById(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": err.Error()})
+			return
+		}
+
+		if err = ctx.Proxies.Stop(projectId); err != nil {
+			if err == proxy.ErrProxyNotRunning {
+				ctx.JSON(&rw, http.StatusConflict, JSON{"err": err.Error()})
+				return
+			}
+
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		ctx.JSON(&rw, http.StatusOK, JSON{
+			"msg":    "Proxy successfully stopped",
+			"status": ctx.Proxies.Status(projectId),
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ihaxolotl/webproxy/internal/data/chain"
+)
+
+// UpdateChainRuleRoute is an endpoint for changing an upstream proxy rule of a
+// project. Only the fields present in the request body are changed.
+func UpdateChainRuleRoute(ctx Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			vars      map[string]string
+			projectId string
+			ruleId    string
+			rule      *chain.Rule
+			err       error
+		)
+
+		vars = mux.Vars(r)
+		projectId = vars["projectId"]
+		ruleId = vars["ruleId"]
+
+		if rule, err = ctx.Database.Chain.FetchById(ruleId); err != nil || rule.ProjectID != projectId {
+			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": "rule not found"})
+			return
+		}
+
+		if err = json.NewDecoder(r.Body).Decode(rule); err != nil {
+			ctx.JSON(&rw, http.StatusUnprocessableEntity, JSON{"err": err.Error()})
+			return
+		}
+
+		rule.ID = ruleId
+		rule.ProjectID = projectId
+
+		if err = rule.Validate(); err != nil {
+			ctx.JSON(&rw, http.StatusUnprocessableEntity, JSON{"err": err.Error()})
+			return
+		}
+
+		if err = ctx.Database.Chain.Update(rule); err != nil {
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		if err = ctx.reloadProxy(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		ctx.JSON(&rw, http.StatusOK, JSON{
+			"msg":  "Rule successfully updated",
+			"rule": rule,
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/ihaxolotl/webproxy/internal/data/settings"
+	"github.com/ihaxolotl/webproxy/internal/proxy"
+)
+
+var (
+	ErrNoListeners       = errors.New("at least one listener is required")
+	ErrDuplicateListener = errors.New("listeners must have distinct addresses")
+)
+
+// validateSettings validates the proxy settings of a project.
+func validateSettings(s *settings.Settings) error {
+	var bound map[string]bool
+
+	if len(s.Listeners) == 0 {
+		return ErrNoListeners
+	}
+
+	bound = make(map[string]bool)
+
+	for _, l := range s.Listeners {
+		if net.ParseIP(l.BindAddress) == nil {
+			return fmt.Errorf("invalid bind address %q", l.BindAddress)
+		}
+
+		if l.Port < 1 || l.Port > 65535 {
+			return fmt.Errorf("invalid port %d", l.Port)
+		}
+
+		if l.Mode != "" && !proxy.ListenerMode(l.Mode).Valid() {
+			return proxy.ErrListenerMode
+		}
+
+		if proxy.ListenerMode(l.Mode) == proxy.ModeReverse {
+			if _, err := proxy.ParseTarget(l.Target); err != nil {
+				return err
+			}
+		}
+
+		addr := net.JoinHostPort(l.BindAddress, strconv.Itoa(l.Port))
+		if bound[addr] {
+			return ErrDuplicateListener
+		}
+		bound[addr] = true
+	}
+
+	if !proxy.DropAction(s.DropAction).ValidDefault() {
+		return proxy.ErrDefaultDrop
+	}
+
+	if !proxy.ValidDropStatus(s.DropStatus) {
+		return proxy.ErrDropStatus
+	}
+
+	return nil
+}
+
+// UpdateProjectSettingsRoute is an endpoint for updating the proxy settings of a
+// project. Only the fields present in the request body are changed. If the proxy
+// is running, interception settings are applied immediately, while changes to the
+// listeners take effect once the proxy is restarted.
+func UpdateProjectSettingsRoute(ctx Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			vars      map[string]string
+			projectId string
+			s         *settings.Settings
+			err       error
+		)
+
+		vars = mux.Vars(r)
+		projectId = vars["projectId"]
+
+		if _, err = ctx.Database.Projects.FetchById(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusNotFound, JSON{"err": err.Error()})
+			return
+		}
+
+		if s, err = ctx.Database.Settings.Fetch(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		if err = json.NewDecoder(r.Body).Decode(s); err != nil {
+			ctx.JSON(&rw, http.StatusUnprocessableEntity, JSON{"err": err.Error()})
+			return
+		}
+
+		s.ProjectID = projectId
+
+		if err = validateSettings(s); err != nil {
+			ctx.JSON(&rw, http.StatusUnprocessableEntity, JSON{"err": err.Error()})
+			return
+		}
+
+		if err = ctx.Database.Settings.Save(s); err != nil {
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		if err = ctx.reloadProxy(projectId); err != nil {
+			ctx.JSON(&rw, http.StatusInternalServerError, JSON{"err": err.Error()})
+			return
+		}
+
+		ctx.JSON(&rw, http.StatusOK, JSON{
+			"msg":      "Settings successfully updated",
+			"settings": s,
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package buffer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MaxHeaderSize is the maximum size of the start line and header section
+// of a message.
+const MaxHeaderSize = 1 << 20
+
+var (
+	ErrHeaderTooLarge = errors.New("header section too large")
+	ErrBadStartLine   = errors.New("malformed start line")
+	ErrBadFraming     = errors.New("malformed message framing")
+	ErrBadChunk       = errors.New("malformed chunk")
+)
+
+// Reader reads consecutive HTTP/1.x messages from a connection. The end of
+// each message is found by following its framing: a Content-Length, chunked
+// transfer encoding, or the connection closing. Messages are returned as the
+// exact bytes that were received, so they can be stored and edited as-is.
+type Reader struct {
+	r   *bufio.Reader
+	buf bytes.Buffer
+}
+
+// NewReader creates a new Reader for a connection.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Buffered returns the bytes read ahead from the connection which do not
+// belong to a message read so far.
+func (r *Reader) Buffered() []byte {
+	b, _ := r.r.Peek(r.r.Buffered())
+	return b
+}
+
+// ReadRequest reads the next request from the connection. If the connection
+// is closed before a request is started, io.EOF is returned. If an error occurs
+// after that, the bytes read so far are returned with it.
+func (r *Reader) ReadRequest() (*Buffer, error) {
+	var (
+		header map[string][]string
+		err    error
+	)
+
+	r.buf.Reset()
+
+	if _, header, err = r.readHeader(); err != nil {
+		return r.message(), err
+	}
+
+	if err = r.readBody(header, false); err != nil {
+		return r.message(), err
+	}
+
+	return r.message(), nil
+}
+
+// ReadResponse reads the next response from the connection to a request
+// made with method. Interim 1xx responses other than 101 Switching Protocols
+// are discarded, since the request has already been sent in full. If an error
+// occurs, the bytes read so far are returned with it.
+func (r *Reader) ReadResponse(method string) (*Buffer, error) {
+	var (
+		line   string
+		header map[string][]string
+		status int
+		err    error
+	)
+
+	for {
+		r.buf.Reset()
+
+		if line, header, err = r.readHeader(); err != nil {
+			return r.message(), err
+		}
+
+		if status, err = parseStatus(line); err != nil {
+			return r.message(), err
+		}
+
+		if status < 100 || status >= 200 || status == 101 {
+			break
+		}
+	}
+
+	// Responses to HEAD requests, informational, 204 No Content and 304 Not
+	// Modified responses, and successful responses to CONNECT have no body.
+	if method == "HEAD" ||
+		status < 200 || status == 204 || status == 304 ||
+		(method == "CONNECT" && status < 300) {
+		return r.message(), nil
+	}
+
+	if err = r.readBody(header, true); err != nil {
+		return r.message(), err
+	}
+
+	return r.message(), nil
+}
+
+// message returns a copy of the message that was read.
+func (r *Reader) message() *Buffer {
+	b := make([]byte, r.buf.Len())
+	copy(b, r.buf.Bytes())
+
+	return NewBufferFrom(b, len(b))
+}
+
+// readLine reads a line terminated by LF, including the terminator. Lines
+// longer than MaxHeaderSize are rejected, so that a stream without any line
+// breaks can't exhaust memory.
+func (r *Reader) readLine() (string, error) {
+	var line []byte
+
+	for {
+		b, err := r.r.ReadSlice('\n')
+		line = append(line, b...)
+		r.buf.Write(b)
+
+		if err == bufio.ErrBufferFull {
+			if len(line) > MaxHeaderSize {
+				return string(line), ErrHeaderTooLarge
+			}
+
+			continue
+		}
+
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return string(line), err
+	}
+}
+
+// readHeader reads the start line and header section of a message. Empty lines
+// sent before the start line are skipped. Header field names are lowercased.
+func (r *Reader) readHeader() (string, map[string][]string, error) {
+	var (
+		start  string
+		line   string
+		header map[string][]string
+		err    error
+	)
+
+	for {
+		if start, err = r.readLine(); err != nil {
+			return "", nil, err
+		}
+
+		if strings.TrimRight(start, "\r\n") != "" {
+			break
+		}
+
+		r.buf.Reset()
+	}
+
+	header = make(map[string][]string)
+
+	for {
+		if line, err = r.readLine(); err != nil {
+			return start, header, eof(err)
+		}
+
+		if r.buf.Len() > MaxHeaderSize {
+			return start, header, ErrHeaderTooLarge
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return start, header, nil
+		}
+
+		// Obsolete line folding continues the previous field value, which
+		// doesn't matter for framing.
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+
+		if i := strings.IndexByte(line, ':'); i > 0 {
+			name := strings.ToLower(strings.TrimSpace(line[:i]))
+			header[name] = append(header[name], strings.TrimSpace(line[i+1:]))
+		}
+	}
+}
+
+// parseStatus parses the status code from the status line of a response.
+func parseStatus(line string) (int, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "HTTP/") || len(fields[1]) != 3 {
+		return 0, ErrBadStartLine
+	}
+
+	status, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, ErrBadStartLine
+	}
+
+	return status, nil
+}
+
+// isChunked reports whether chunked is the final transfer coding of a message.
+func isChunked(header map[string][]string) (bool, bool) {
+	var codings []string
+
+	te, ok := header["transfer-encoding"]
+	if !ok {
+		return false, false
+	}
+
+	for _, v := range te {
+		for _, coding := range strings.Split(v, ",") {
+			if coding = strings.TrimSpace(coding); coding != "" {
+				codings = append(codings, strings.ToLower(coding))
+			}
+		}
+	}
+
+	return len(codings) > 0 && codings[len(codings)-1] == "chunked", true
+}
+
+// contentLength returns the value of the Content-Length header, or -1 if it is
+// not present. Repeated headers must all have the same value.
+func contentLength(header map[string][]string) (int64, error) {
+	var length int64 = -1
+
+	for _, v := range header["content-length"] {
+		for _, s := range strings.Split(v, ",") {
+			n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+			if err != nil || n < 0 || (length != -1 && n != length) {
+				return 0, ErrBadFraming
+			}
+
+			length = n
+		}
+	}
+
+	return length, nil
+}
+
+// readBody reads the body of a message according to its framing. When there is
+// no Content-Length or chunked encoding, requests have no body and responses end
+// when the connection is closed.
+func (r *Reader) readBody(header map[string][]string, response bool) error {
+	var (
+		chunked bool
+		coded   bool
+		length  int64
+		err     error
+	)
+
+	// Transfer-Encoding overrides Content-Length.
+	if chunked, coded = isChunked(header); chunked {
+		return r.readChunked()
+	}
+
+	if coded {
+		if !response {
+			return ErrBadFraming
+		}
+
+		return r.readUntilEOF()
+	}
+
+	if length, err = contentLength(header); err != nil {
+		return err
+	}
+
+	if length >= 0 {
+		return r.readN(length)
+	}
+
+	if response {
+		return r.readUntilEOF()
+	}
+
+	return nil
+}
+
+// readN reads exactly n bytes.
+func (r *Reader) readN(n int64) error {
+	_, err := io.CopyN(&r.buf, r.r, n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// readUntilEOF reads until the connection is closed.
+func (r *Reader) readUntilEOF() error {
+	_, err := io.Copy(&r.buf, r.r)
+	return err
+}
+
+// readChunked reads a chunked body, including the last chunk and trailer section.
+func (r *Reader) readChunked() error {
+	var (
+		line string
+		size uint64
+		err  error
+	)
+
+	for {
+		if line, err = r.readLine(); err != nil {
+			return eof(err)
+		}
+
+		// Chunk extensions follow the chunk size.
+		line = strings.TrimRight(line, "\r\n")
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+
+		if size, err = strconv.ParseUint(strings.TrimSpace(line), 16, 63); err != nil {
+			return ErrBadChunk
+		}
+
+		if size == 0 {
+			break
+		}
+
+		if err = r.readN(int64(size)); err != nil {
+			return err
+		}
+
+		if line, err = r.readLine(); err != nil {
+			return eof(err)
+		}
+
+		if strings.TrimRight(line, "\r\n") != "" {
+			return ErrBadChunk
+		}
+	}
+
+	// Read the trailer section.
+	for {
+		if line, err = r.readLine(); err != nil {
+			return eof(err)
+		}
+
+		if strings.TrimRight(line, "\r\n") == "" {
+			return nil
+		}
+	}
+}
+
+// eof converts io.EOF to io.ErrUnexpectedEOF for reads in the middle of a message.
+func eof(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
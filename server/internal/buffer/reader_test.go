@@ -0,0 +1,116 @@
+package buffer
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var testRequests = []string{
+	"GET / HTTP/1.1\r\nHost: localhost\r\n\r\n",
+	"POST /form HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\n\r\nhello world",
+	"POST /upload HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n6\r\n world\r\n0\r\nX-Trailer: 1\r\n\r\n",
+	"POST /large HTTP/1.1\r\nHost: localhost\r\nContent-Length: 100000\r\n\r\n" + strings.Repeat("A", 100000),
+}
+
+func TestReaderReadRequest(t *testing.T) {
+	for _, raw := range testRequests {
+		// Read one byte at a time to simulate a request split across many segments.
+		r := NewReader(iotest.OneByteReader(strings.NewReader(raw)))
+
+		buf, err := r.ReadRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(buf.Buffer()) != raw {
+			t.Fatalf("fatal: read %d bytes, expected %d bytes.\n", buf.Size(), len(raw))
+		}
+	}
+}
+
+func TestReaderPipelined(t *testing.T) {
+	r := NewReader(strings.NewReader(strings.Join(testRequests, "")))
+
+	for _, raw := range testRequests {
+		buf, err := r.ReadRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(buf.Buffer()) != raw {
+			t.Fatalf("fatal: read %q, expected %q.\n", buf.Buffer(), raw)
+		}
+	}
+
+	if _, err := r.ReadRequest(); err != io.EOF {
+		t.Fatalf("fatal: expected EOF, got %v.\n", err)
+	}
+}
+
+func TestReaderReadResponse(t *testing.T) {
+	tests := []struct {
+		method   string
+		raw      string
+		expected string
+	}{
+		{
+			"GET",
+			"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhelloHTTP/1.1 200 OK\r\n",
+			"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello",
+		},
+		{
+			"GET",
+			"HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip, chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n",
+			"HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip, chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n",
+		},
+		{
+			"GET",
+			"HTTP/1.0 200 OK\r\nServer: close-delimited\r\n\r\nbody until close",
+			"HTTP/1.0 200 OK\r\nServer: close-delimited\r\n\r\nbody until close",
+		},
+		{
+			"HEAD",
+			"HTTP/1.1 200 OK\r\nContent-Length: 1000\r\n\r\n",
+			"HTTP/1.1 200 OK\r\nContent-Length: 1000\r\n\r\n",
+		},
+		{
+			"GET",
+			"HTTP/1.1 304 Not Modified\r\nContent-Length: 1000\r\n\r\n",
+			"HTTP/1.1 304 Not Modified\r\nContent-Length: 1000\r\n\r\n",
+		},
+		{
+			"POST",
+			"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n",
+			"HTTP/1.1 204 No Content\r\n\r\n",
+		},
+	}
+
+	for _, test := range tests {
+		r := NewReader(iotest.OneByteReader(strings.NewReader(test.raw)))
+
+		buf, err := r.ReadResponse(test.method)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(buf.Buffer()) != test.expected {
+			t.Fatalf("fatal: read %q, expected %q.\n", buf.Buffer(), test.expected)
+		}
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	raw := "POST / HTTP/1.1\r\nContent-Length: 100\r\n\r\nshort"
+
+	buf, err := NewReader(strings.NewReader(raw)).ReadRequest()
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("fatal: expected unexpected EOF, got %v.\n", err)
+	}
+
+	if !bytes.Equal(buf.Buffer(), []byte(raw)) {
+		t.Fatalf("fatal: read %q, expected %q.\n", buf.Buffer(), raw)
+	}
+}
@@ -0,0 +1,243 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// AuthorityValidity is the lifetime of a generated root certificate.
+	AuthorityValidity = 10 * 365 * 24 * time.Hour
+
+	// LeafValidity is the lifetime of a generated leaf certificate. Some
+	// platforms reject server certificates valid for more than 825 days.
+	LeafValidity = 365 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidPEM = errors.New("invalid PEM data")
+	ErrNoHostname = errors.New("no hostname for leaf certificate")
+)
+
+// Authority is a certificate authority used to sign the leaf certificates
+// presented to clients when their TLS connections are intercepted.
+type Authority struct {
+	Certificate *x509.Certificate // Root certificate
+	PrivateKey  crypto.Signer     // Root certificate private key
+}
+
+// serialNumber returns a random 128-bit certificate serial number.
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// NewAuthority generates a new self-signed root certificate and private key.
+func NewAuthority(commonName string) (*Authority, error) {
+	var (
+		key    *ecdsa.PrivateKey
+		serial *big.Int
+		tmpl   *x509.Certificate
+		der    []byte
+		cert   *x509.Certificate
+		err    error
+	)
+
+	if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return nil, err
+	}
+
+	if serial, err = serialNumber(); err != nil {
+		return nil, err
+	}
+
+	tmpl = &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   commonName,
+			Organization: []string{"WebProxy"},
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(AuthorityValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	if der, err = x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key); err != nil {
+		return nil, err
+	}
+
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		return nil, err
+	}
+
+	return &Authority{Certificate: cert, PrivateKey: key}, nil
+}
+
+// ParseAuthority parses a PEM encoded root certificate and private key.
+func ParseAuthority(certPEM, keyPEM []byte) (*Authority, error) {
+	var (
+		block *pem.Block
+		cert  *x509.Certificate
+		key   interface{}
+		err   error
+	)
+
+	if block, _ = pem.Decode(certPEM); block == nil || block.Type != "CERTIFICATE" {
+		return nil, ErrInvalidPEM
+	}
+
+	if cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+		return nil, err
+	}
+
+	if block, _ = pem.Decode(keyPEM); block == nil || block.Type != "PRIVATE KEY" {
+		return nil, ErrInvalidPEM
+	}
+
+	if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrInvalidPEM
+	}
+
+	return &Authority{Certificate: cert, PrivateKey: signer}, nil
+}
+
+// DER returns the DER encoding of the root certificate.
+func (ca *Authority) DER() []byte {
+	return ca.Certificate.Raw
+}
+
+// CertificatePEM returns the PEM encoding of the root certificate.
+func (ca *Authority) CertificatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate.Raw})
+}
+
+// PrivateKeyPEM returns the PKCS #8, PEM encoding of the root private key.
+func (ca *Authority) PrivateKeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(ca.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// Sign generates a leaf certificate for a hostname or IP address signed by
+// the authority.
+func (ca *Authority) Sign(host string) (*tls.Certificate, error) {
+	var (
+		key    *ecdsa.PrivateKey
+		serial *big.Int
+		tmpl   *x509.Certificate
+		der    []byte
+		err    error
+	)
+
+	if host == "" {
+		return nil, ErrNoHostname
+	}
+
+	if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return nil, err
+	}
+
+	if serial, err = serialNumber(); err != nil {
+		return nil, err
+	}
+
+	tmpl = &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   host,
+			Organization: []string{"WebProxy"},
+		},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(LeafValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
+	}
+
+	der, err = x509.CreateCertificate(rand.Reader, tmpl, ca.Certificate, key.Public(), ca.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.Certificate.Raw},
+		PrivateKey:  key,
+	}, nil
+}
+
+// Cache signs leaf certificates on demand and keeps them for reuse, so
+// only the first connection to a host pays for key generation.
+type Cache struct {
+	mu    sync.Mutex
+	ca    *Authority
+	certs map[string]*tls.Certificate
+}
+
+// NewCache creates a new leaf certificate cache for an authority.
+func NewCache(ca *Authority) *Cache {
+	return &Cache{
+		ca:    ca,
+		certs: make(map[string]*tls.Certificate),
+	}
+}
+
+// Get returns the cached leaf certificate for a host, signing a new one if
+// it hasn't been seen before.
+func (c *Cache) Get(host string) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cert, ok := c.certs[host]; ok {
+		return cert, nil
+	}
+
+	cert, err := c.ca.Sign(host)
+	if err != nil {
+		return nil, err
+	}
+
+	c.certs[host] = cert
+
+	return cert, nil
+}
+
+// Config returns a TLS server configuration which presents leaf certificates
+// for the server name sent by the client. Clients that don't send the SNI
+// extension are given a certificate for the fallback host.
+func (c *Cache) Config(fallback string) *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "" {
+				return c.Get(hello.ServerName)
+			}
+
+			return c.Get(fallback)
+		},
+	}
+}
@@ -0,0 +1,76 @@
+package certs
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestAuthoritySign(t *testing.T) {
+	ca, err := NewAuthority("Test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, host := range []string{"example.com", "127.0.0.1"} {
+		leaf, err := ca.Sign(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cert, err := x509.ParseCertificate(leaf.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.Certificate)
+
+		if _, err = cert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Fatalf("fatal: leaf certificate for %s does not verify: %v\n", host, err)
+		}
+	}
+}
+
+func TestAuthorityParse(t *testing.T) {
+	ca, err := NewAuthority("Test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPEM, err := ca.PrivateKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseAuthority(ca.CertificatePEM(), keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !parsed.Certificate.Equal(ca.Certificate) {
+		t.Fatal("fatal: parsed certificate does not match the original.")
+	}
+}
+
+func TestCacheGet(t *testing.T) {
+	ca, err := NewAuthority("Test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewCache(ca)
+
+	first, err := cache.Get("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := cache.Get("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second {
+		t.Fatal("fatal: cached certificate was signed twice.")
+	}
+}
@@ -0,0 +1,332 @@
+package chain
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// Protocols spoken to an upstream proxy.
+const (
+	ProtocolDirect = "direct" // Connect to the target without a proxy
+	ProtocolHTTP   = "http"   // Tunnel through an HTTP proxy with CONNECT
+	ProtocolSOCKS5 = "socks5" // Tunnel through a SOCKS5 proxy
+)
+
+var (
+	ErrInvalidProtocol = errors.New("protocol must be direct, http or socks5")
+	ErrInvalidAddress  = errors.New("address must be a host:port pair")
+	ErrNoUsername      = errors.New("a password requires a username")
+	ErrLongCredentials = errors.New("socks5 usernames and passwords are at most 255 bytes")
+)
+
+// maxSOCKSCredential is the longest username or password SOCKS5 can send.
+const maxSOCKSCredential = 255
+
+// Rule picks the upstream proxy that connections to matching targets are made
+// through. The enabled rules of a project are evaluated in order, and the first
+// rule whose host pattern matches the target's hostname is used. Targets that
+// match no rule are connected to directly. The password is write-only: it is
+// read from JSON but never written to it.
+type Rule struct {
+	ID        string `json:"id"`        // Unique ID of the rule.
+	ProjectID string `json:"projectId"` // Unique ID of the parent project.
+	Position  int64  `json:"position"`  // Order in which the rule is evaluated.
+	Enabled   bool   `json:"enabled"`   // Flag for whether the rule is evaluated.
+	Host      string `json:"host"`      // Regular expression matched against the target's hostname.
+	Protocol  string `json:"protocol"`  // Protocol spoken to the upstream proxy.
+	Address   string `json:"address"`   // Address of the upstream proxy as host:port.
+	Username  string `json:"username"`  // Username sent to the upstream proxy, if any.
+	Password  string `json:"-"`         // Password sent to the upstream proxy, if any.
+}
+
+// UnmarshalJSON decodes a rule along with its password. A rule decoded from JSON
+// without a password field keeps the password it had.
+func (r *Rule) UnmarshalJSON(b []byte) error {
+	type rule Rule
+
+	in := struct {
+		*rule
+		Password *string `json:"password"`
+	}{rule: (*rule)(r)}
+
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+
+	if in.Password != nil {
+		r.Password = *in.Password
+	}
+
+	return nil
+}
+
+// Validate checks that a rule can be evaluated and its proxy dialed.
+func (r *Rule) Validate() error {
+	switch r.Protocol {
+	case ProtocolDirect:
+	case ProtocolHTTP, ProtocolSOCKS5:
+		host, port, err := net.SplitHostPort(r.Address)
+		if err != nil || host == "" || port == "" {
+			return ErrInvalidAddress
+		}
+
+		if r.Password != "" && r.Username == "" {
+			return ErrNoUsername
+		}
+
+		if r.Protocol == ProtocolSOCKS5 &&
+			(len(r.Username) > maxSOCKSCredential || len(r.Password) > maxSOCKSCredential) {
+			return ErrLongCredentials
+		}
+	default:
+		return ErrInvalidProtocol
+	}
+
+	_, err := regexp.Compile(r.Host)
+	return err
+}
+
+type RulesTable struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *RulesTable {
+	return &RulesTable{db}
+}
+
+// Create creates the "chain_rules" table if it doesn't already exist.
+func (t RulesTable) Create() (err error) {
+	_, err = t.db.Exec(`
+		CREATE TABLE IF NOT EXISTS chain_rules (
+			id TEXT PRIMARY KEY NOT NULL UNIQUE,
+			projectid TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			enabled BOOLEAN NOT NULL CHECK (enabled IN (0, 1)),
+			host TEXT NOT NULL,
+			protocol TEXT NOT NULL,
+			address TEXT NOT NULL,
+			username TEXT NOT NULL,
+			password TEXT NOT NULL
+		);
+	`)
+
+	return err
+}
+
+// Insert inserts a new rule after the existing rules of its project.
+func (t RulesTable) Insert(r *Rule) (err error) {
+	var stmt *sql.Stmt
+
+	stmt, err = t.db.Prepare(`
+		INSERT INTO chain_rules(
+			id,
+			projectid,
+			position,
+			enabled,
+			host,
+			protocol,
+			address,
+			username,
+			password
+		) VALUES (
+			?,
+			?,
+			(SELECT COALESCE(MAX(position), 0) + 1 FROM chain_rules WHERE projectid = ?),
+			?, ?, ?, ?, ?, ?
+		) RETURNING position;
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	r.ID = uuid.New().String()
+
+	return stmt.QueryRow(
+		r.ID,
+		r.ProjectID,
+		r.ProjectID,
+		r.Enabled,
+		r.Host,
+		r.Protocol,
+		r.Address,
+		r.Username,
+		r.Password,
+	).Scan(&r.Position)
+}
+
+// Update replaces the fields of an existing rule.
+func (t RulesTable) Update(r *Rule) (err error) {
+	var (
+		stmt *sql.Stmt
+		res  sql.Result
+		n    int64
+	)
+
+	stmt, err = t.db.Prepare(`
+		UPDATE
+			chain_rules
+		SET
+			position = ?,
+			enabled = ?,
+			host = ?,
+			protocol = ?,
+			address = ?,
+			username = ?,
+			password = ?
+		WHERE
+			id = ?;
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	res, err = stmt.Exec(
+		r.Position,
+		r.Enabled,
+		r.Host,
+		r.Protocol,
+		r.Address,
+		r.Username,
+		r.Password,
+		r.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if n, err = res.RowsAffected(); err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Delete deletes a rule.
+func (t RulesTable) Delete(id string) (err error) {
+	var (
+		res sql.Result
+		n   int64
+	)
+
+	if res, err = t.db.Exec(`DELETE FROM chain_rules WHERE id = ?;`, id); err != nil {
+		return err
+	}
+
+	if n, err = res.RowsAffected(); err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// scan scans a rule from a row.
+func scan(row interface{ Scan(...interface{}) error }) (*Rule, error) {
+	r := &Rule{}
+
+	err := row.Scan(
+		&r.ID,
+		&r.ProjectID,
+		&r.Position,
+		&r.Enabled,
+		&r.Host,
+		&r.Protocol,
+		&r.Address,
+		&r.Username,
+		&r.Password,
+	)
+
+	return r, err
+}
+
+// Fetch returns the rules of a project in the order they are evaluated.
+func (t RulesTable) Fetch(projectId string) (rules []Rule, err error) {
+	var (
+		stmt *sql.Stmt
+		rows *sql.Rows
+	)
+
+	stmt, err = t.db.Prepare(`
+		SELECT
+			id,
+			projectid,
+			position,
+			enabled,
+			host,
+			protocol,
+			address,
+			username,
+			password
+		FROM
+			chain_rules
+		WHERE
+			projectid = ?
+		ORDER BY
+			position;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	if rows, err = stmt.Query(projectId); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules = make([]Rule, 0)
+
+	for rows.Next() {
+		r, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, *r)
+	}
+
+	return rules, rows.Err()
+}
+
+// FetchById returns a single rule by its id.
+func (t RulesTable) FetchById(id string) (r *Rule, err error) {
+	var stmt *sql.Stmt
+
+	stmt, err = t.db.Prepare(`
+		SELECT
+			id,
+			projectid,
+			position,
+			enabled,
+			host,
+			protocol,
+			address,
+			username,
+			password
+		FROM
+			chain_rules
+		WHERE
+			id = ?
+		LIMIT 0, 1;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	return scan(stmt.QueryRow(id))
+}
@@ -0,0 +1,154 @@
+package chain
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+)
+
+const DatabasePath = "/tmp/db.sqlite"
+
+var testExampleRule = &Rule{
+	ProjectID: uuid.New().String(),
+	Enabled:   true,
+	Host:      `(^|\.)example\.com$`,
+	Protocol:  ProtocolSOCKS5,
+	Address:   "127.0.0.1:1080",
+	Username:  "analyst",
+	Password:  "hunter2",
+}
+
+func testTable() *RulesTable {
+	file, err := os.Create(DatabasePath)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	db, err := sql.Open("sqlite", DatabasePath)
+	if err != nil {
+		panic(err)
+	}
+
+	table := &RulesTable{db}
+	if err = table.Create(); err != nil {
+		panic(err)
+	}
+
+	return table
+}
+
+func TestRuleValidate(t *testing.T) {
+	invalid := []Rule{
+		{Protocol: "socks4", Address: "127.0.0.1:1080"},
+		{Protocol: ProtocolHTTP, Address: "127.0.0.1"},
+		{Protocol: ProtocolHTTP, Address: ":8080"},
+		{Protocol: ProtocolSOCKS5, Address: "127.0.0.1:1080", Password: "hunter2"},
+		{Protocol: ProtocolDirect, Host: "("},
+		{Protocol: ProtocolSOCKS5, Address: "127.0.0.1:1080", Username: strings.Repeat("u", 256)},
+		{Protocol: ProtocolSOCKS5, Address: "127.0.0.1:1080", Username: "analyst", Password: strings.Repeat("p", 256)},
+	}
+
+	for _, r := range invalid {
+		if err := r.Validate(); err == nil {
+			t.Fatalf("fatal: invalid rule passed validation: %+v\n", r)
+		}
+	}
+
+	if err := testExampleRule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRuleJSON(t *testing.T) {
+	rule := *testExampleRule
+
+	b, err := json.Marshal(&rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(b), rule.Password) {
+		t.Fatalf("fatal: password was returned in %s\n", b)
+	}
+
+	if err = json.Unmarshal([]byte(`{"address":"127.0.0.1:1081"}`), &rule); err != nil {
+		t.Fatal(err)
+	}
+
+	if rule.Address != "127.0.0.1:1081" || rule.Password != testExampleRule.Password {
+		t.Fatalf("fatal: password was not kept by a partial update: %+v\n", rule)
+	}
+
+	if err = json.Unmarshal([]byte(`{"password":"swordfish"}`), &rule); err != nil {
+		t.Fatal(err)
+	}
+
+	if rule.Password != "swordfish" {
+		t.Fatalf("fatal: password was not decoded: %+v\n", rule)
+	}
+}
+
+func TestRuleInsertAndFetch(t *testing.T) {
+	table := testTable()
+	n := 3
+
+	for i := 0; i < n; i++ {
+		if err := table.Insert(testExampleRule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rules, err := table.Fetch(testExampleRule.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rules) != n {
+		t.Fatalf("fatal: %d results expected, %d results returned.\n", n, len(rules))
+	}
+
+	for i, r := range rules {
+		if r.Position != int64(i+1) {
+			t.Fatalf("fatal: rule %d has position %d.\n", i, r.Position)
+		}
+	}
+}
+
+func TestRuleUpdateAndDelete(t *testing.T) {
+	table := testTable()
+
+	if err := table.Insert(testExampleRule); err != nil {
+		t.Fatal(err)
+	}
+
+	updated := *testExampleRule
+	updated.Protocol = ProtocolHTTP
+	updated.Address = "proxy.corp:3128"
+
+	if err := table.Update(&updated); err != nil {
+		t.Fatal(err)
+	}
+
+	fetched, err := table.FetchById(updated.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fetched.Protocol != updated.Protocol || fetched.Address != updated.Address {
+		t.Fatalf("fatal: %s %s expected, %s %s fetched.\n", updated.Protocol, updated.Address, fetched.Protocol, fetched.Address)
+	}
+
+	if err = table.Delete(updated.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = table.FetchById(updated.ID); err != sql.ErrNoRows {
+		t.Fatalf("fatal: deleted rule was fetched: %v\n", err)
+	}
+}
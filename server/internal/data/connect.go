@@ -4,10 +4,16 @@ import (
 	"database/sql"
 	"os"
 
+	"github.com/ihaxolotl/webproxy/internal/data/chain"
 	"github.com/ihaxolotl/webproxy/internal/data/history"
+	"github.com/ihaxolotl/webproxy/internal/data/intercept"
+	"github.com/ihaxolotl/webproxy/internal/data/messages"
 	"github.com/ihaxolotl/webproxy/internal/data/projects"
+	"github.com/ihaxolotl/webproxy/internal/data/replace"
 	"github.com/ihaxolotl/webproxy/internal/data/requests"
 	"github.com/ihaxolotl/webproxy/internal/data/responses"
+	"github.com/ihaxolotl/webproxy/internal/data/scope"
+	"github.com/ihaxolotl/webproxy/internal/data/settings"
 	_ "modernc.org/sqlite"
 )
 
@@ -23,29 +29,42 @@ type Database struct {
 	Requests  *requests.RequestsTable
 	Responses *responses.ResponseTable
 	History   *history.HistoryView
+	Settings  *settings.SettingsTable
+	Intercept *intercept.RulesTable
+	Replace   *replace.RulesTable
+	Scope     *scope.RulesTable
+	Chain     *chain.RulesTable
+	Messages  *messages.MessagesTable
 }
 
 func New() *Database {
 	return &Database{}
 }
 
-// Connect opens the SQLite3 database.
-func (db *Database) connect() (*sql.DB, error) {
-	file, err := os.Create(DatabasePath)
+// Connect opens the SQLite3 database at path.
+func (db *Database) connect(path string) (*sql.DB, error) {
+	file, err := os.Create(path)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	return sql.Open("sqlite", DatabasePath)
+	// Connections write concurrently, so writers wait for each other rather
+	// than failing while the database is locked.
+	return sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)")
 }
 
 // SetupDatabase connects to the database instance and creates the
 // necessary tables.
-func (db *Database) Setup() (err error) {
+func (db *Database) Setup() error {
+	return db.SetupAt(DatabasePath)
+}
+
+// SetupAt connects to the database at path and creates the necessary tables.
+func (db *Database) SetupAt(path string) (err error) {
 	var tables []Table
 
-	if db.conn, err = db.connect(); err != nil {
+	if db.conn, err = db.connect(path); err != nil {
 		return err
 	}
 
@@ -53,12 +72,24 @@ func (db *Database) Setup() (err error) {
 	db.Requests = requests.New(db.conn)
 	db.Responses = responses.New(db.conn)
 	db.History = history.New(db.conn)
+	db.Settings = settings.New(db.conn)
+	db.Intercept = intercept.New(db.conn)
+	db.Replace = replace.New(db.conn)
+	db.Scope = scope.New(db.conn)
+	db.Chain = chain.New(db.conn)
+	db.Messages = messages.New(db.conn)
 
 	tables = []Table{
 		db.Projects,
 		db.Requests,
 		db.Responses,
 		db.History,
+		db.Settings,
+		db.Intercept,
+		db.Replace,
+		db.Scope,
+		db.Chain,
+		db.Messages,
 	}
 	for _, t := range tables {
 		if err = t.Create(); err != nil {
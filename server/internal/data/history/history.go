@@ -2,24 +2,45 @@ package history
 
 import (
 	"database/sql"
+	"net/url"
 	"time"
+
+	"github.com/ihaxolotl/webproxy/internal/data/scope"
 )
 
 type HistoryEntry struct {
 	Index      int64     `json:"idx"`        // Request order index
 	Method     string    `json:"method"`     // HTTP request method
 	Status     int16     `json:"status"`     // HTTP response status code
+	Scheme     string    `json:"scheme"`     // URL scheme of the request
 	Target     string    `json:"target"`     // Target domain
 	URL        string    `json:"url"`        // URL of the requested resource
+	Protocol   string    `json:"protocol"`   // Protocol version the request was sent with
 	IPAddr     string    `json:"ipaddr"`     // Internet address of the target
+	Upstream   string    `json:"upstream"`   // Upstream proxy the request was sent through
 	Length     int64     `json:"length"`     // Length of the response in bytes
+	Mimetype   string    `json:"mimetype"`   // Mime-type of the response body
+	DNSTime    int64     `json:"dnsTime"`    // Time taken to resolve the target's hostname
+	Connect    int64     `json:"connect"`    // Time taken to connect to the target
+	TLSTime    int64     `json:"tlsTime"`    // Time taken by the TLS handshake with the target
+	TTFB       int64     `json:"ttfb"`       // Time until the first byte of the response
+	Total      int64     `json:"total"`      // Total time taken by the exchange
 	Timestamp  time.Time `json:"timestamp"`  // Timestamp of when the request was made
 	Edited     bool      `json:"edited"`     // Flag for whether the request was modified
 	Comment    string    `json:"comment"`    // Comment for the request
+	State      string    `json:"state"`      // State of the exchange
+	Error      string    `json:"error"`      // Error that interrupted the exchange
+	ErrorKind  string    `json:"errorKind"`  // Classification of the error
+	InScope    bool      `json:"inScope"`    // Flag for whether the request is in the project's current scope
 	RequestId  string    `json:"requestId"`  // Unique ID of the request
 	ResponseId string    `json:"responseId"` // Unique ID of the response
 }
 
+// Filter narrows down the history entries that are fetched.
+type Filter struct {
+	InScope bool // Only fetch requests in the project's scope
+}
+
 type HistoryView struct {
 	db *sql.DB
 }
@@ -32,38 +53,81 @@ func (v HistoryView) Create() (err error) {
 	return nil
 }
 
-func (v HistoryView) Fetch(projectId string) (history []HistoryEntry, err error) {
+// target returns the URL of an entry's request. Requests that could not be
+// parsed only have a scheme.
+func (h *HistoryEntry) target() *url.URL {
+	u, err := url.ParseRequestURI(h.URL)
+	if err != nil {
+		u = &url.URL{}
+	}
+
+	u.Scheme = h.Scheme
+	u.Host = h.Target
+
+	return u
+}
+
+// Fetch returns the history of requests made through a project's proxy. Exchanges
+// that were interrupted before a response was received are included without one.
+// Entries keep their index in the full history when a filter is applied. Whether
+// an entry is in scope is decided by the project's current scope rules, so that
+// rules added later also apply to the requests made before them.
+func (v HistoryView) Fetch(projectId string, filter Filter) (history []HistoryEntry, err error) {
 	var (
-		stmt *sql.Stmt
-		rows *sql.Rows
+		stmt  *sql.Stmt
+		rows  *sql.Rows
+		rules []scope.Rule
 	)
 
+	if rules, err = scope.New(v.db).Fetch(projectId); err != nil {
+		return nil, err
+	}
+	inScope := scope.Compile(rules)
+
 	stmt, err = v.db.Prepare(`
 		SELECT
-			ROW_NUMBER () OVER ( ORDER BY req.timestamp ) idx,
-			req.method as method,
-			res.status as status,
-			req.domain as target,
-			req.url as url,
-			req.ipaddr as ipaddr,
-			res.length as length,
-			req.timestamp as timestamp,
-			req.edited as edited,
-			req.comment as comment,
-			req.id as requestid,
-			res.id as responseid
-		FROM
-			requests req
-		INNER JOIN
-			responses res
-		ON
-			req.id = res.requestid
-		INNER JOIN
-			projects proj
-		ON
-			proj.id = res.projectid
-		WHERE
-			proj.id = ?;
+			*
+		FROM (
+			SELECT
+				ROW_NUMBER () OVER ( ORDER BY req.timestamp ) idx,
+				req.method as method,
+				COALESCE(res.status, 0) as status,
+				req.scheme as scheme,
+				req.domain as target,
+				req.url as url,
+				req.protocol as protocol,
+				req.ipaddr as ipaddr,
+				req.upstream as upstream,
+				COALESCE(res.length, 0) as length,
+				COALESCE(res.mimetype, '') as mimetype,
+				COALESCE(res.dnstime, 0) as dnstime,
+				COALESCE(res.connect, 0) as connect,
+				COALESCE(res.tlstime, 0) as tlstime,
+				COALESCE(res.ttfb, 0) as ttfb,
+				COALESCE(res.total, 0) as total,
+				req.timestamp as timestamp,
+				req.edited as edited,
+				req.comment as comment,
+				req.state as state,
+				req.error as error,
+				req.errorkind as errorkind,
+				req.id as requestid,
+				COALESCE(res.id, '') as responseid
+			FROM
+				requests req
+			LEFT JOIN
+				responses res
+			ON
+				req.id = res.requestid
+			INNER JOIN
+				projects proj
+			ON
+				proj.id = req.projectid
+			WHERE
+				proj.id = ?
+		)
+		ORDER BY
+			idx;
 	`)
 	if err != nil {
 		return nil, err
@@ -84,19 +148,35 @@ func (v HistoryView) Fetch(projectId string) (history []HistoryEntry, err error)
 			&h.Index,
 			&h.Method,
 			&h.Status,
+			&h.Scheme,
 			&h.Target,
 			&h.URL,
+			&h.Protocol,
 			&h.IPAddr,
+			&h.Upstream,
 			&h.Length,
+			&h.Mimetype,
+			&h.DNSTime,
+			&h.Connect,
+			&h.TLSTime,
+			&h.TTFB,
+			&h.Total,
 			&h.Timestamp,
 			&h.Edited,
 			&h.Comment,
+			&h.State,
+			&h.Error,
+			&h.ErrorKind,
 			&h.RequestId,
 			&h.ResponseId,
 		); err != nil {
 			return nil, err
 		}
 
+		if h.InScope = inScope.Contains(h.target()); filter.InScope && !h.InScope {
+			continue
+		}
+
 		history = append(history, h)
 	}
 
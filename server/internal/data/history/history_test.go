@@ -12,6 +12,7 @@ import (
 	"github.com/ihaxolotl/webproxy/internal/data/projects"
 	"github.com/ihaxolotl/webproxy/internal/data/requests"
 	"github.com/ihaxolotl/webproxy/internal/data/responses"
+	"github.com/ihaxolotl/webproxy/internal/data/scope"
 	_ "modernc.org/sqlite"
 )
 
@@ -54,9 +55,10 @@ func testCreateRequirements(db *sql.DB) {
 	proj := projects.New(db)
 	req := requests.New(db)
 	res := responses.New(db)
+	rules := scope.New(db)
 	n := 3
 
-	tables := []testTable{proj, req, res}
+	tables := []testTable{proj, req, res, rules}
 
 	for _, t := range tables {
 		if err := t.Create(); err != nil {
@@ -71,20 +73,28 @@ func testCreateRequirements(db *sql.DB) {
 	for i := 0; i < n; i++ {
 		reqid := uuid.New().String()
 		resid := uuid.New().String()
+		domain := "example.com"
+
+		if i == 0 {
+			domain = "localhost"
+		}
 
 		testExampleRequest := &requests.Request{
 			ID:         reqid,
 			ProjectID:  testExampleProject.ID,
 			ResponseID: resid,
 			Method:     http.MethodGet,
-			Domain:     "localhost",
+			Scheme:     "http",
+			Domain:     domain,
 			IPAddr:     "127.0.0.1",
 			URL:        "/",
+			Protocol:   "HTTP/1.1",
 			Length:     18,
 			Edited:     true,
 			Timestamp:  time.Now(),
 			Comment:    "SQL injection.",
-			Raw:        "GET / HTTP/1.1\r\n\r\n",
+			State:      "complete",
+			Raw:        []byte("GET / HTTP/1.1\r\n\r\n"),
 		}
 
 		testExampleResponse := &responses.Response{
@@ -98,7 +108,7 @@ func testCreateRequirements(db *sql.DB) {
 			Timestamp: time.Now(),
 			Mimetype:  "text/html",
 			Comment:   "SQL injection.",
-			Raw:       "HTTP/1.0 200 OK\r\nServer: SimpleHTTP/0.6 Python/3.10.1\r\nDate: Fri, 17 Dec 2021 10:45:06 GMT\r\nContent-type: text/html; charset=utf-8\r\nContent-Length: 0\r\n\r\n",
+			Raw:       []byte("HTTP/1.0 200 OK\r\nServer: SimpleHTTP/0.6 Python/3.10.1\r\nDate: Fri, 17 Dec 2021 10:45:06 GMT\r\nContent-type: text/html; charset=utf-8\r\nContent-Length: 0\r\n\r\n"),
 		}
 
 		if _, err := req.InsertAndFetch(testExampleRequest); err != nil {
@@ -115,7 +125,7 @@ func TestHistoryFetch(t *testing.T) {
 	view := testView()
 	n := 3
 
-	records, err := view.Fetch(testExampleProject.ID)
+	records, err := view.Fetch(testExampleProject.ID, Filter{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -126,3 +136,120 @@ func TestHistoryFetch(t *testing.T) {
 
 	fmt.Printf("%v\n", records)
 }
+
+func TestHistoryFetchWithoutResponse(t *testing.T) {
+	view := testView()
+	n := 4
+
+	testMalformedRequest := &requests.Request{
+		ID:        uuid.New().String(),
+		ProjectID: testExampleProject.ID,
+		Scheme:    "http",
+		Length:    9,
+		Timestamp: time.Now(),
+		State:     requests.StateParseError,
+		Error:     "malformed HTTP request",
+		Raw:       []byte("\x16\x03\x01\x00\xa5\x01\x00\x00\xa1"),
+	}
+
+	if _, err := requests.New(view.db).Insert(testMalformedRequest); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := view.Fetch(testExampleProject.ID, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != n {
+		t.Fatalf("fatal: %d results expected, %d results returned.\n", n, len(records))
+	}
+
+	for _, h := range records {
+		if h.RequestId == testMalformedRequest.ID && h.ResponseId != "" {
+			t.Fatalf("fatal: unexpected response for malformed request: %+v\n", h)
+		}
+	}
+}
+
+func testMalformedRequest(t *testing.T, view *HistoryView) *requests.Request {
+	r := &requests.Request{
+		ID:        uuid.New().String(),
+		ProjectID: testExampleProject.ID,
+		Scheme:    "http",
+		Length:    9,
+		Timestamp: time.Now(),
+		State:     requests.StateParseError,
+		Error:     "malformed HTTP request",
+		Raw:       []byte("\x16\x03\x01\x00\xa5\x01\x00\x00\xa1"),
+	}
+
+	if _, err := requests.New(view.db).Insert(r); err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestHistoryFetchInScope(t *testing.T) {
+	view := testView()
+	n := 2
+
+	// Scope rules apply to the requests made before they were added.
+	rule := &scope.Rule{
+		ProjectID: testExampleProject.ID,
+		Enabled:   true,
+		Action:    scope.ActionInclude,
+		Host:      "example.com",
+	}
+	if err := scope.New(view.db).Insert(rule); err != nil {
+		t.Fatal(err)
+	}
+	testMalformedRequest(t, view)
+
+	records, err := view.Fetch(testExampleProject.ID, Filter{InScope: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != n {
+		t.Fatalf("fatal: %d results expected, %d results returned.\n", n, len(records))
+	}
+
+	for i, h := range records {
+		if !h.InScope || h.Index != int64(i+2) {
+			t.Fatalf("fatal: unexpected entry: %+v\n", h)
+		}
+	}
+}
+
+func TestHistoryFetchInScopeWithoutIncludeRules(t *testing.T) {
+	view := testView()
+	n := 3
+
+	// Without include rules, every request is in scope unless it is excluded,
+	// including requests that could not be parsed.
+	rule := &scope.Rule{
+		ProjectID: testExampleProject.ID,
+		Enabled:   true,
+		Action:    scope.ActionExclude,
+		Host:      "localhost",
+	}
+	if err := scope.New(view.db).Insert(rule); err != nil {
+		t.Fatal(err)
+	}
+	malformed := testMalformedRequest(t, view)
+
+	records, err := view.Fetch(testExampleProject.ID, Filter{InScope: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != n {
+		t.Fatalf("fatal: %d results expected, %d results returned.\n", n, len(records))
+	}
+
+	if h := records[n-1]; !h.InScope || h.RequestId != malformed.ID {
+		t.Fatalf("fatal: malformed request expected in scope: %+v\n", h)
+	}
+}
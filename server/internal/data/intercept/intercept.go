@@ -0,0 +1,336 @@
+package intercept
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Directions of the messages a rule applies to.
+const (
+	DirectionRequest  = "request"
+	DirectionResponse = "response"
+)
+
+// Operators combining a rule with the rules before it.
+const (
+	OperatorAnd = "and"
+	OperatorOr  = "or"
+)
+
+// Fields of a message a rule can match.
+const (
+	FieldHost        = "host"         // Hostname of the target
+	FieldMethod      = "method"       // Request method
+	FieldPath        = "path"         // Path of the requested resource
+	FieldExtension   = "extension"    // File extension of the requested resource
+	FieldHeader      = "header"       // Value of a header
+	FieldStatus      = "status"       // Response status code
+	FieldContentType = "content_type" // Content-Type of the message
+)
+
+var (
+	ErrInvalidDirection = errors.New("direction must be request or response")
+	ErrInvalidOperator  = errors.New("operator must be and or or")
+	ErrInvalidField     = errors.New("unknown rule field")
+	ErrNoHeader         = errors.New("header rules require a header name")
+	ErrResponseField    = errors.New("status rules only apply to responses")
+)
+
+// Rule is a condition on whether a request or response is intercepted. The rules
+// for a direction are evaluated in order, each combined with the result of the
+// rules before it by its operator. Extension rules match a comma-separated list
+// of extensions; every other field is matched with a regular expression.
+type Rule struct {
+	ID        string `json:"id"`        // Unique ID of the rule.
+	ProjectID string `json:"projectId"` // Unique ID of the parent project.
+	Position  int64  `json:"position"`  // Order in which the rule is evaluated.
+	Enabled   bool   `json:"enabled"`   // Flag for whether the rule is evaluated.
+	Direction string `json:"direction"` // Direction of the messages the rule applies to.
+	Operator  string `json:"operator"`  // Operator combining the rule with the previous rules.
+	Negate    bool   `json:"negate"`    // Flag for whether the match is inverted.
+	Field     string `json:"field"`     // Field of the message that is matched.
+	Header    string `json:"header"`    // Name of the header matched by header rules.
+	Pattern   string `json:"pattern"`   // Pattern the field is matched against.
+}
+
+// Validate checks that a rule can be evaluated.
+func (r *Rule) Validate() error {
+	if r.Direction != DirectionRequest && r.Direction != DirectionResponse {
+		return ErrInvalidDirection
+	}
+
+	if r.Operator != OperatorAnd && r.Operator != OperatorOr {
+		return ErrInvalidOperator
+	}
+
+	switch r.Field {
+	case FieldHost, FieldMethod, FieldPath, FieldContentType:
+	case FieldExtension:
+		return nil
+	case FieldHeader:
+		if strings.TrimSpace(r.Header) == "" {
+			return ErrNoHeader
+		}
+	case FieldStatus:
+		if r.Direction != DirectionResponse {
+			return ErrResponseField
+		}
+	default:
+		return ErrInvalidField
+	}
+
+	_, err := regexp.Compile(r.Pattern)
+	return err
+}
+
+type RulesTable struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *RulesTable {
+	return &RulesTable{db}
+}
+
+// Create creates the "intercept_rules" table if it doesn't already exist.
+func (t RulesTable) Create() (err error) {
+	_, err = t.db.Exec(`
+		CREATE TABLE IF NOT EXISTS intercept_rules (
+			id TEXT PRIMARY KEY NOT NULL UNIQUE,
+			projectid TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			enabled BOOLEAN NOT NULL CHECK (enabled IN (0, 1)),
+			direction TEXT NOT NULL,
+			operator TEXT NOT NULL,
+			negate BOOLEAN NOT NULL CHECK (negate IN (0, 1)),
+			field TEXT NOT NULL,
+			header TEXT NOT NULL,
+			pattern TEXT NOT NULL
+		);
+	`)
+
+	return err
+}
+
+// Insert inserts a new rule after the existing rules of its project.
+func (t RulesTable) Insert(r *Rule) (err error) {
+	var stmt *sql.Stmt
+
+	stmt, err = t.db.Prepare(`
+		INSERT INTO intercept_rules(
+			id,
+			projectid,
+			position,
+			enabled,
+			direction,
+			operator,
+			negate,
+			field,
+			header,
+			pattern
+		) VALUES (
+			?,
+			?,
+			(SELECT COALESCE(MAX(position), 0) + 1 FROM intercept_rules WHERE projectid = ?),
+			?, ?, ?, ?, ?, ?, ?
+		) RETURNING position;
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	r.ID = uuid.New().String()
+
+	return stmt.QueryRow(
+		r.ID,
+		r.ProjectID,
+		r.ProjectID,
+		r.Enabled,
+		r.Direction,
+		r.Operator,
+		r.Negate,
+		r.Field,
+		r.Header,
+		r.Pattern,
+	).Scan(&r.Position)
+}
+
+// Update replaces the fields of an existing rule.
+func (t RulesTable) Update(r *Rule) (err error) {
+	var (
+		stmt *sql.Stmt
+		res  sql.Result
+		n    int64
+	)
+
+	stmt, err = t.db.Prepare(`
+		UPDATE
+			intercept_rules
+		SET
+			position = ?,
+			enabled = ?,
+			direction = ?,
+			operator = ?,
+			negate = ?,
+			field = ?,
+			header = ?,
+			pattern = ?
+		WHERE
+			id = ?;
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	res, err = stmt.Exec(
+		r.Position,
+		r.Enabled,
+		r.Direction,
+		r.Operator,
+		r.Negate,
+		r.Field,
+		r.Header,
+		r.Pattern,
+		r.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if n, err = res.RowsAffected(); err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Delete deletes a rule.
+func (t RulesTable) Delete(id string) (err error) {
+	var (
+		res sql.Result
+		n   int64
+	)
+
+	if res, err = t.db.Exec(`DELETE FROM intercept_rules WHERE id = ?;`, id); err != nil {
+		return err
+	}
+
+	if n, err = res.RowsAffected(); err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// scan scans a rule from a row.
+func scan(row interface{ Scan(...interface{}) error }) (*Rule, error) {
+	r := &Rule{}
+
+	err := row.Scan(
+		&r.ID,
+		&r.ProjectID,
+		&r.Position,
+		&r.Enabled,
+		&r.Direction,
+		&r.Operator,
+		&r.Negate,
+		&r.Field,
+		&r.Header,
+		&r.Pattern,
+	)
+
+	return r, err
+}
+
+// Fetch returns the rules of a project in the order they are evaluated.
+func (t RulesTable) Fetch(projectId string) (rules []Rule, err error) {
+	var (
+		stmt *sql.Stmt
+		rows *sql.Rows
+	)
+
+	stmt, err = t.db.Prepare(`
+		SELECT
+			id,
+			projectid,
+			position,
+			enabled,
+			direction,
+			operator,
+			negate,
+			field,
+			header,
+			pattern
+		FROM
+			intercept_rules
+		WHERE
+			projectid = ?
+		ORDER BY
+			position;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	if rows, err = stmt.Query(projectId); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules = make([]Rule, 0)
+
+	for rows.Next() {
+		r, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, *r)
+	}
+
+	return rules, rows.Err()
+}
+
+// FetchById returns a single rule by its id.
+func (t RulesTable) FetchById(id string) (r *Rule, err error) {
+	var stmt *sql.Stmt
+
+	stmt, err = t.db.Prepare(`
+		SELECT
+			id,
+			projectid,
+			position,
+			enabled,
+			direction,
+			operator,
+			negate,
+			field,
+			header,
+			pattern
+		FROM
+			intercept_rules
+		WHERE
+			id = ?
+		LIMIT 0, 1;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	return scan(stmt.QueryRow(id))
+}
@@ -0,0 +1,120 @@
+package intercept
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+)
+
+const DatabasePath = "/tmp/db.sqlite"
+
+var testExampleRule = &Rule{
+	ProjectID: uuid.New().String(),
+	Enabled:   true,
+	Direction: DirectionRequest,
+	Operator:  OperatorAnd,
+	Negate:    true,
+	Field:     FieldExtension,
+	Pattern:   "js,css,png",
+}
+
+func testTable() *RulesTable {
+	file, err := os.Create(DatabasePath)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	db, err := sql.Open("sqlite", DatabasePath)
+	if err != nil {
+		panic(err)
+	}
+
+	table := &RulesTable{db}
+	if err = table.Create(); err != nil {
+		panic(err)
+	}
+
+	return table
+}
+
+func TestRuleValidate(t *testing.T) {
+	invalid := []Rule{
+		{Direction: "both", Operator: OperatorAnd, Field: FieldHost},
+		{Direction: DirectionRequest, Operator: "xor", Field: FieldHost},
+		{Direction: DirectionRequest, Operator: OperatorAnd, Field: FieldStatus, Pattern: "200"},
+		{Direction: DirectionRequest, Operator: OperatorAnd, Field: FieldHeader, Pattern: ".*"},
+		{Direction: DirectionRequest, Operator: OperatorAnd, Field: FieldPath, Pattern: "("},
+	}
+
+	for _, r := range invalid {
+		if err := r.Validate(); err == nil {
+			t.Fatalf("fatal: invalid rule passed validation: %+v\n", r)
+		}
+	}
+
+	if err := testExampleRule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRuleInsertAndFetch(t *testing.T) {
+	table := testTable()
+	n := 3
+
+	for i := 0; i < n; i++ {
+		if err := table.Insert(testExampleRule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rules, err := table.Fetch(testExampleRule.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rules) != n {
+		t.Fatalf("fatal: %d results expected, %d results returned.\n", n, len(rules))
+	}
+
+	for i, r := range rules {
+		if r.Position != int64(i+1) {
+			t.Fatalf("fatal: rule %d has position %d.\n", i, r.Position)
+		}
+	}
+}
+
+func TestRuleUpdateAndDelete(t *testing.T) {
+	table := testTable()
+
+	if err := table.Insert(testExampleRule); err != nil {
+		t.Fatal(err)
+	}
+
+	updated := *testExampleRule
+	updated.Pattern = "svg"
+
+	if err := table.Update(&updated); err != nil {
+		t.Fatal(err)
+	}
+
+	fetched, err := table.FetchById(updated.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fetched.Pattern != updated.Pattern {
+		t.Fatalf("fatal: pattern %q expected, %q fetched.\n", updated.Pattern, fetched.Pattern)
+	}
+
+	if err = table.Delete(updated.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = table.FetchById(updated.ID); err != sql.ErrNoRows {
+		t.Fatalf("fatal: deleted rule was fetched: %v\n", err)
+	}
+}
@@ -0,0 +1,172 @@
+package messages
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Directions of WebSocket messages.
+const (
+	DirectionClient = "client" // Sent by the client to the server
+	DirectionServer = "server" // Sent by the server to the client
+)
+
+// Message represents a WebSocket frame relayed by the proxy over a connection
+// that was upgraded by a request.
+type Message struct {
+	ID        string    `json:"id"`        // Unique ID of the message.
+	ProjectID string    `json:"projectId"` // Unique ID of the parent project.
+	RequestID string    `json:"requestId"` // Unique ID of the request that upgraded the connection.
+	Direction string    `json:"direction"` // Side of the connection that sent the message.
+	Opcode    string    `json:"opcode"`    // Frame type, such as text, binary or close.
+	Final     bool      `json:"final"`     // Flag for whether the frame ends a fragmented message.
+	Length    int64     `json:"length"`    // Length of the payload in bytes.
+	Edited    bool      `json:"edited"`    // Flag for whether the payload was modified or not.
+	Dropped   bool      `json:"dropped"`   // Flag for whether the message was dropped by the proxy.
+	Timestamp time.Time `json:"timestamp"` // Time the message was received.
+	Payload   []byte    `json:"payload"`   // Unmasked payload as forwarded, base64-encoded in JSON.
+	Original  []byte    `json:"original"`  // Unmasked payload before it was edited, if it was.
+}
+
+type MessagesTable struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *MessagesTable {
+	return &MessagesTable{db}
+}
+
+// Create creates the "websocket_messages" table if it doesn't already exist.
+func (t MessagesTable) Create() (err error) {
+	_, err = t.db.Exec(`
+		CREATE TABLE IF NOT EXISTS websocket_messages (
+			id TEXT PRIMARY KEY NOT NULL UNIQUE,
+			projectid TEXT NOT NULL,
+			requestid TEXT NOT NULL,
+			direction TEXT NOT NULL,
+			opcode TEXT NOT NULL,
+			final BOOLEAN NOT NULL CHECK (final IN (0, 1)),
+			length INTEGER NOT NULL,
+			edited BOOLEAN NOT NULL CHECK (edited IN (0, 1)),
+			dropped BOOLEAN NOT NULL CHECK (dropped IN (0, 1)),
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+			payload BLOB,
+			original BLOB
+		);
+	`)
+
+	return err
+}
+
+// Insert inserts a new record into the websocket_messages table.
+func (t MessagesTable) Insert(m *Message) (err error) {
+	var stmt *sql.Stmt
+
+	stmt, err = t.db.Prepare(`
+		INSERT INTO websocket_messages(
+			id,
+			projectid,
+			requestid,
+			direction,
+			opcode,
+			final,
+			length,
+			edited,
+			dropped,
+			timestamp,
+			payload,
+			original
+		) VALUES (
+			?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		);
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(
+		m.ID,
+		m.ProjectID,
+		m.RequestID,
+		m.Direction,
+		m.Opcode,
+		m.Final,
+		m.Length,
+		m.Edited,
+		m.Dropped,
+		m.Timestamp,
+		m.Payload,
+		m.Original,
+	)
+
+	return err
+}
+
+// Fetch returns the messages relayed over the connection upgraded by a request,
+// in the order they were received.
+func (t MessagesTable) Fetch(requestId string) (msgs []Message, err error) {
+	var (
+		stmt *sql.Stmt
+		rows *sql.Rows
+	)
+
+	stmt, err = t.db.Prepare(`
+		SELECT
+			id,
+			projectid,
+			requestid,
+			direction,
+			opcode,
+			final,
+			length,
+			edited,
+			dropped,
+			timestamp,
+			payload,
+			original
+		FROM
+			websocket_messages
+		WHERE
+			requestid = ?
+		ORDER BY
+			rowid;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	if rows, err = stmt.Query(requestId); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	msgs = make([]Message, 0)
+
+	for rows.Next() {
+		m := Message{}
+
+		err = rows.Scan(
+			&m.ID,
+			&m.ProjectID,
+			&m.RequestID,
+			&m.Direction,
+			&m.Opcode,
+			&m.Final,
+			&m.Length,
+			&m.Edited,
+			&m.Dropped,
+			&m.Timestamp,
+			&m.Payload,
+			&m.Original,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs = append(msgs, m)
+	}
+
+	return msgs, rows.Err()
+}
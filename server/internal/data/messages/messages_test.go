@@ -0,0 +1,89 @@
+package messages
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+)
+
+const DatabasePath = "/tmp/db.sqlite"
+
+func testTable() *MessagesTable {
+	file, err := os.Create(DatabasePath)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	db, err := sql.Open("sqlite", DatabasePath)
+	if err != nil {
+		panic(err)
+	}
+
+	table := &MessagesTable{db}
+	if err = table.Create(); err != nil {
+		panic(err)
+	}
+
+	return table
+}
+
+func TestMessageInsertAndFetch(t *testing.T) {
+	table := testTable()
+
+	requestId := uuid.New().String()
+	inserted := []Message{
+		{
+			Direction: DirectionClient,
+			Opcode:    "text",
+			Payload:   []byte("hello"),
+			Original:  []byte("hi"),
+			Edited:    true,
+		},
+		{
+			Direction: DirectionServer,
+			Opcode:    "binary",
+			Payload:   []byte{0x00, 0xff, 0xfe},
+			Dropped:   true,
+		},
+		{
+			Direction: DirectionServer,
+			Opcode:    "ping",
+		},
+	}
+
+	for i := range inserted {
+		m := &inserted[i]
+		m.ID = uuid.New().String()
+		m.ProjectID = uuid.New().String()
+		m.RequestID = requestId
+		m.Final = true
+		m.Length = int64(len(m.Payload))
+		m.Timestamp = time.Now()
+
+		if err := table.Insert(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fetched, err := table.Fetch(requestId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fetched) != len(inserted) {
+		t.Fatalf("fatal: %d messages inserted, %d fetched.\n", len(inserted), len(fetched))
+	}
+
+	for i, m := range fetched {
+		if m.ID != inserted[i].ID || !bytes.Equal(m.Payload, inserted[i].Payload) ||
+			!bytes.Equal(m.Original, inserted[i].Original) || m.Edited != inserted[i].Edited || m.Dropped != inserted[i].Dropped {
+			t.Fatalf("fatal: %+v inserted, %+v fetched.\n", inserted[i], m)
+		}
+	}
+}
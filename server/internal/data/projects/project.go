@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ihaxolotl/webproxy/internal/certs"
 )
 
 // Project respresents the data for an engagement.
@@ -30,7 +31,9 @@ func (t ProjectsTable) Create() (err error) {
 			id TEXT PRIMARY KEY NOT NULL UNIQUE,
 			title TEXT NOT NULL,
 			description TEXT NULL,
-			created DATETIME DEFAULT CURRENT_TIMESTAMP
+			created DATETIME DEFAULT CURRENT_TIMESTAMP,
+			cacert TEXT NOT NULL,
+			cakey TEXT NOT NULL
 		);
 	`)
 
@@ -38,14 +41,20 @@ func (t ProjectsTable) Create() (err error) {
 }
 
 // Insert inserts a new record into the projects table and returns the last inserted
-// rowid or an error.
+// rowid or an error. A new root certificate authority is generated for the project,
+// so that every engagement has its own trust anchor for TLS interception.
 func (t ProjectsTable) Insert(p *Project) (rowid int64, err error) {
-	var stmt *sql.Stmt
+	var (
+		stmt   *sql.Stmt
+		ca     *certs.Authority
+		cakey  []byte
+		cacert []byte
+	)
 
 	stmt, err = t.db.Prepare(`
 		INSERT INTO projects(
-			id, title, description, created
-		) VALUES (?, ?, ?, ?);
+			id, title, description, created, cacert, cakey
+		) VALUES (?, ?, ?, ?, ?, ?);
 	`)
 	if err != nil {
 		return 0, err
@@ -55,11 +64,22 @@ func (t ProjectsTable) Insert(p *Project) (rowid int64, err error) {
 	p.ID = uuid.New().String()
 	p.Created = time.Now()
 
+	if ca, err = certs.NewAuthority(authorityName(p.ID)); err != nil {
+		return 0, err
+	}
+
+	cacert = ca.CertificatePEM()
+	if cakey, err = ca.PrivateKeyPEM(); err != nil {
+		return 0, err
+	}
+
 	res, err := stmt.Exec(
 		p.ID,
 		p.Title,
 		p.Description,
 		p.Created,
+		string(cacert),
+		string(cakey),
 	)
 	if err != nil {
 		return 0, err
@@ -166,6 +186,87 @@ func (t ProjectsTable) FetchById(id string) (p *Project, err error) {
 	return p, err
 }
 
+// authorityName returns the common name of a project's root certificate.
+func authorityName(id string) string {
+	return "WebProxy CA " + id
+}
+
+// FetchAuthority returns the root certificate authority of a project.
+func (t ProjectsTable) FetchAuthority(id string) (ca *certs.Authority, err error) {
+	var (
+		stmt   *sql.Stmt
+		cacert string
+		cakey  string
+	)
+
+	stmt, err = t.db.Prepare(`
+		SELECT
+			cacert, cakey
+		FROM
+			projects
+		WHERE
+			id = ?
+		LIMIT 0, 1;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	if err = stmt.QueryRow(id).Scan(&cacert, &cakey); err != nil {
+		return nil, err
+	}
+
+	return certs.ParseAuthority([]byte(cacert), []byte(cakey))
+}
+
+// RegenerateAuthority replaces the root certificate authority of a project with
+// a newly generated one and returns it. Certificates signed by the previous
+// authority will no longer be trusted by clients that only trust the new one.
+func (t ProjectsTable) RegenerateAuthority(id string) (ca *certs.Authority, err error) {
+	var (
+		stmt  *sql.Stmt
+		res   sql.Result
+		cakey []byte
+		n     int64
+	)
+
+	stmt, err = t.db.Prepare(`
+		UPDATE
+			projects
+		SET
+			cacert = ?, cakey = ?
+		WHERE
+			id = ?;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	if ca, err = certs.NewAuthority(authorityName(id)); err != nil {
+		return nil, err
+	}
+
+	if cakey, err = ca.PrivateKeyPEM(); err != nil {
+		return nil, err
+	}
+
+	if res, err = stmt.Exec(string(ca.CertificatePEM()), string(cakey), id); err != nil {
+		return nil, err
+	}
+
+	if n, err = res.RowsAffected(); err != nil {
+		return nil, err
+	}
+
+	if n == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return ca, nil
+}
+
 func (t ProjectsTable) FetchHistory(id string) (err error) {
 	return nil
 }
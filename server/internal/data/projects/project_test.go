@@ -98,3 +98,48 @@ func TestProjectFetchById(t *testing.T) {
 		t.Fatalf("fatal: inserted ID (%s) does not match fetched ID (%s).\n", inserted.ID, fetched.ID)
 	}
 }
+
+func TestProjectFetchAuthority(t *testing.T) {
+	table := testTable()
+
+	inserted := testExampleProject
+	if err := testInsertAndGetProject(table); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := table.FetchAuthority(inserted.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProjectRegenerateAuthority(t *testing.T) {
+	table := testTable()
+
+	inserted := testExampleProject
+	if err := testInsertAndGetProject(table); err != nil {
+		t.Fatal(err)
+	}
+
+	original, err := table.FetchAuthority(inserted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regenerated, err := table.RegenerateAuthority(inserted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetched, err := table.FetchAuthority(inserted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fetched.Certificate.Equal(original.Certificate) {
+		t.Fatal("fatal: authority was not regenerated.")
+	}
+
+	if !fetched.Certificate.Equal(regenerated.Certificate) {
+		t.Fatal("fatal: fetched authority does not match the regenerated authority.")
+	}
+}
@@ -0,0 +1,329 @@
+package replace
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// Directions of the messages a rule applies to.
+const (
+	DirectionRequest  = "request"
+	DirectionResponse = "response"
+)
+
+// Parts of a message a rule can rewrite.
+const (
+	LocationStartLine = "start_line" // Request line or status line
+	LocationHeaders   = "headers"    // Header fields, one per line
+	LocationBody      = "body"       // Message body
+)
+
+var (
+	ErrInvalidDirection = errors.New("direction must be request or response")
+	ErrInvalidLocation  = errors.New("location must be start_line, headers or body")
+	ErrNoMatch          = errors.New("match must not be empty")
+)
+
+// Rule rewrites the requests or responses passing through the proxy. Every
+// occurrence of the match in the rule's part of a message is replaced. Regular
+// expression rules may refer to submatches in the replacement with $1, ${name}.
+type Rule struct {
+	ID        string `json:"id"`        // Unique ID of the rule.
+	ProjectID string `json:"projectId"` // Unique ID of the parent project.
+	Position  int64  `json:"position"`  // Order in which the rule is applied.
+	Enabled   bool   `json:"enabled"`   // Flag for whether the rule is applied.
+	Direction string `json:"direction"` // Direction of the messages the rule applies to.
+	Location  string `json:"location"`  // Part of the message that is rewritten.
+	Regex     bool   `json:"regex"`     // Flag for whether the match is a regular expression.
+	Match     string `json:"match"`     // Text or pattern to find.
+	Replace   string `json:"replace"`   // Replacement for the matched text.
+	Comment   string `json:"comment"`   // Description of the rule.
+	Hits      int64  `json:"hits"`      // Number of messages the rule has rewritten.
+}
+
+// Validate checks that a rule can be applied.
+func (r *Rule) Validate() error {
+	if r.Direction != DirectionRequest && r.Direction != DirectionResponse {
+		return ErrInvalidDirection
+	}
+
+	switch r.Location {
+	case LocationStartLine, LocationHeaders, LocationBody:
+	default:
+		return ErrInvalidLocation
+	}
+
+	if r.Match == "" {
+		return ErrNoMatch
+	}
+
+	if r.Regex {
+		if _, err := regexp.Compile(r.Match); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type RulesTable struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *RulesTable {
+	return &RulesTable{db}
+}
+
+// Create creates the "replace_rules" table if it doesn't already exist.
+func (t RulesTable) Create() (err error) {
+	_, err = t.db.Exec(`
+		CREATE TABLE IF NOT EXISTS replace_rules (
+			id TEXT PRIMARY KEY NOT NULL UNIQUE,
+			projectid TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			enabled BOOLEAN NOT NULL CHECK (enabled IN (0, 1)),
+			direction TEXT NOT NULL,
+			location TEXT NOT NULL,
+			regex BOOLEAN NOT NULL CHECK (regex IN (0, 1)),
+			match TEXT NOT NULL,
+			replace TEXT NOT NULL,
+			comment TEXT NOT NULL,
+			hits INTEGER NOT NULL DEFAULT 0
+		);
+	`)
+
+	return err
+}
+
+// Insert inserts a new rule after the existing rules of its project.
+func (t RulesTable) Insert(r *Rule) (err error) {
+	var stmt *sql.Stmt
+
+	stmt, err = t.db.Prepare(`
+		INSERT INTO replace_rules(
+			id,
+			projectid,
+			position,
+			enabled,
+			direction,
+			location,
+			regex,
+			match,
+			replace,
+			comment
+		) VALUES (
+			?,
+			?,
+			(SELECT COALESCE(MAX(position), 0) + 1 FROM replace_rules WHERE projectid = ?),
+			?, ?, ?, ?, ?, ?, ?
+		) RETURNING position;
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	r.ID = uuid.New().String()
+	r.Hits = 0
+
+	return stmt.QueryRow(
+		r.ID,
+		r.ProjectID,
+		r.ProjectID,
+		r.Enabled,
+		r.Direction,
+		r.Location,
+		r.Regex,
+		r.Match,
+		r.Replace,
+		r.Comment,
+	).Scan(&r.Position)
+}
+
+// Update replaces the fields of an existing rule. The hit count is kept.
+func (t RulesTable) Update(r *Rule) (err error) {
+	var (
+		stmt *sql.Stmt
+		res  sql.Result
+		n    int64
+	)
+
+	stmt, err = t.db.Prepare(`
+		UPDATE
+			replace_rules
+		SET
+			position = ?,
+			enabled = ?,
+			direction = ?,
+			location = ?,
+			regex = ?,
+			match = ?,
+			replace = ?,
+			comment = ?
+		WHERE
+			id = ?;
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	res, err = stmt.Exec(
+		r.Position,
+		r.Enabled,
+		r.Direction,
+		r.Location,
+		r.Regex,
+		r.Match,
+		r.Replace,
+		r.Comment,
+		r.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if n, err = res.RowsAffected(); err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// IncrementHits records that a rule has rewritten another message.
+func (t RulesTable) IncrementHits(id string) (err error) {
+	_, err = t.db.Exec(`UPDATE replace_rules SET hits = hits + 1 WHERE id = ?;`, id)
+	return err
+}
+
+// Delete deletes a rule.
+func (t RulesTable) Delete(id string) (err error) {
+	var (
+		res sql.Result
+		n   int64
+	)
+
+	if res, err = t.db.Exec(`DELETE FROM replace_rules WHERE id = ?;`, id); err != nil {
+		return err
+	}
+
+	if n, err = res.RowsAffected(); err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// scan scans a rule from a row.
+func scan(row interface{ Scan(...interface{}) error }) (*Rule, error) {
+	r := &Rule{}
+
+	err := row.Scan(
+		&r.ID,
+		&r.ProjectID,
+		&r.Position,
+		&r.Enabled,
+		&r.Direction,
+		&r.Location,
+		&r.Regex,
+		&r.Match,
+		&r.Replace,
+		&r.Comment,
+		&r.Hits,
+	)
+
+	return r, err
+}
+
+// Fetch returns the rules of a project in the order they are applied.
+func (t RulesTable) Fetch(projectId string) (rules []Rule, err error) {
+	var (
+		stmt *sql.Stmt
+		rows *sql.Rows
+	)
+
+	stmt, err = t.db.Prepare(`
+		SELECT
+			id,
+			projectid,
+			position,
+			enabled,
+			direction,
+			location,
+			regex,
+			match,
+			replace,
+			comment,
+			hits
+		FROM
+			replace_rules
+		WHERE
+			projectid = ?
+		ORDER BY
+			position;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	if rows, err = stmt.Query(projectId); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules = make([]Rule, 0)
+
+	for rows.Next() {
+		r, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, *r)
+	}
+
+	return rules, rows.Err()
+}
+
+// FetchById returns a single rule by its id.
+func (t RulesTable) FetchById(id string) (r *Rule, err error) {
+	var stmt *sql.Stmt
+
+	stmt, err = t.db.Prepare(`
+		SELECT
+			id,
+			projectid,
+			position,
+			enabled,
+			direction,
+			location,
+			regex,
+			match,
+			replace,
+			comment,
+			hits
+		FROM
+			replace_rules
+		WHERE
+			id = ?
+		LIMIT 0, 1;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	return scan(stmt.QueryRow(id))
+}
@@ -5,6 +5,25 @@ import (
 	"time"
 )
 
+// Exchange states recorded on requests.
+const (
+	StateComplete   = "complete"    // The response was received and sent to the client.
+	StateParseError = "parse_error" // The request or response could not be parsed.
+	StateDropped    = "dropped"     // The request or response was dropped at the control panel.
+	StateFailed     = "failed"      // The target server could not be reached.
+)
+
+// Classifications of the errors that prevent the proxy from reaching a target.
+const (
+	ErrorKindDNS     = "dns"     // The target's hostname could not be resolved.
+	ErrorKindRefused = "refused" // The target refused the connection.
+	ErrorKindTimeout = "timeout" // The target did not answer in time.
+	ErrorKindTLS     = "tls"     // The TLS handshake with the target failed.
+	ErrorKindReset   = "reset"   // The target reset or closed the connection.
+	ErrorKindNetwork = "network" // Any other network error.
+	ErrorKindProxy   = "proxy"   // The upstream proxy could not connect to the target.
+)
+
 // Request represents an HTTP request and its metadata that has
 // been intercepted by the proxy.
 type Request struct {
@@ -12,14 +31,22 @@ type Request struct {
 	ProjectID  string    `json:"projectId"`  // Unique ID of the parent project.
 	ResponseID string    `json:"responseId"` // Unique ID of the corresponding response.
 	Method     string    `json:"method"`     // HTTP method of the request.
+	Scheme     string    `json:"scheme"`     // URL scheme of the request (http or https).
 	Domain     string    `json:"domain"`     // Domain name of the target host.
 	IPAddr     string    `json:"ipaddr"`     // Internet address of the target host.
+	Upstream   string    `json:"upstream"`   // Upstream proxy the request was sent through, if any.
 	URL        string    `json:"url"`        // URL of the requested resource.
+	Protocol   string    `json:"protocol"`   // Protocol version the request was sent with, such as HTTP/1.1 or HTTP/2.0.
 	Length     int64     `json:"length"`     // Length of the request in bytes.
 	Edited     bool      `json:"edited"`     // Flag for whether the request was modified or not.
 	Timestamp  time.Time `json:"timestamp"`  // Time the request was made.
 	Comment    string    `json:"comment"`    // User-supplied comment on the request.
-	Raw        string    `json:"raw"`        // Raw request bytes.
+	InScope    bool      `json:"inScope"`    // Flag for whether the request is in the project's scope.
+	State      string    `json:"state"`      // State of the exchange the request belongs to.
+	Error      string    `json:"error"`      // Error that interrupted the exchange, if any.
+	ErrorKind  string    `json:"errorKind"`  // Classification of the error, if the target could not be reached.
+	Raw        []byte    `json:"raw"`        // Raw request bytes as forwarded, base64-encoded in JSON.
+	Original   []byte    `json:"original"`   // Raw request bytes before they were edited, if they were.
 }
 
 type RequestsTable struct {
@@ -38,14 +65,22 @@ func (t RequestsTable) Create() (err error) {
 			projectid TEXT NOT NULL,
 			responseid TEXT NOT NULL,
 			method TEXT NOT NULL,
+			scheme TEXT NOT NULL,
 			domain TEXT NOT NULL,
 			ipaddr TEXT NOT NULL,
+			upstream TEXT NOT NULL,
 			url TEXT NOT NULL,
+			protocol TEXT NOT NULL,
 			length INTEGER,
 			edited BOOLEAN NOT NULL CHECK (edited IN (0, 1)),
 			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
 			comment TEXT,
-			raw TEXT
+			inscope BOOLEAN NOT NULL CHECK (inscope IN (0, 1)),
+			state TEXT NOT NULL,
+			error TEXT NOT NULL,
+			errorkind TEXT NOT NULL,
+			raw BLOB,
+			original BLOB
 		);
 	`)
 
@@ -66,16 +101,24 @@ func (t RequestsTable) Insert(req *Request) (rowid int64, err error) {
 			projectid,
 			responseid,
 			method,
+			scheme,
 			domain,
 			ipaddr,
+			upstream,
 			url,
+			protocol,
 			length,
 			edited,
 			timestamp,
 			comment,
-			raw
+			inscope,
+			state,
+			error,
+			errorkind,
+			raw,
+			original
 		) VALUES (
-			?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+			?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
 		);
 	`)
 	if err != nil {
@@ -87,14 +130,22 @@ func (t RequestsTable) Insert(req *Request) (rowid int64, err error) {
 		req.ProjectID,
 		req.ResponseID,
 		req.Method,
+		req.Scheme,
 		req.Domain,
 		req.IPAddr,
+		req.Upstream,
 		req.URL,
+		req.Protocol,
 		req.Length,
 		req.Edited,
 		req.Timestamp,
 		req.Comment,
+		req.InScope,
+		req.State,
+		req.Error,
+		req.ErrorKind,
 		req.Raw,
+		req.Original,
 	)
 	if err != nil {
 		return 0, err
@@ -122,14 +173,22 @@ func (t RequestsTable) InsertAndFetch(r *Request) (req *Request, err error) {
 			projectid,
 			responseid,
 			method,
+			scheme,
 			domain,
 			ipaddr,
+			upstream,
 			url,
+			protocol,
 			length,
 			edited,
 			timestamp,
 			comment,
-			raw
+			inscope,
+			state,
+			error,
+			errorkind,
+			raw,
+			original
 		FROM
 			requests
 		WHERE
@@ -147,14 +206,22 @@ func (t RequestsTable) InsertAndFetch(r *Request) (req *Request, err error) {
 		&req.ProjectID,
 		&req.ResponseID,
 		&req.Method,
+		&req.Scheme,
 		&req.Domain,
 		&req.IPAddr,
+		&req.Upstream,
 		&req.URL,
+		&req.Protocol,
 		&req.Length,
 		&req.Edited,
 		&req.Timestamp,
 		&req.Comment,
+		&req.InScope,
+		&req.State,
+		&req.Error,
+		&req.ErrorKind,
 		&req.Raw,
+		&req.Original,
 	)
 
 	return req, err
@@ -171,14 +238,22 @@ func (t RequestsTable) FetchById(id string) (req *Request, err error) {
 			projectid,
 			responseid,
 			method,
+			scheme,
 			domain,
 			ipaddr,
+			upstream,
 			url,
+			protocol,
 			length,
 			edited,
 			timestamp,
 			comment,
-			raw
+			inscope,
+			state,
+			error,
+			errorkind,
+			raw,
+			original
 		FROM
 			requests
 		WHERE
@@ -196,14 +271,22 @@ func (t RequestsTable) FetchById(id string) (req *Request, err error) {
 		&req.ProjectID,
 		&req.ResponseID,
 		&req.Method,
+		&req.Scheme,
 		&req.Domain,
 		&req.IPAddr,
+		&req.Upstream,
 		&req.URL,
+		&req.Protocol,
 		&req.Length,
 		&req.Edited,
 		&req.Timestamp,
 		&req.Comment,
+		&req.InScope,
+		&req.State,
+		&req.Error,
+		&req.ErrorKind,
 		&req.Raw,
+		&req.Original,
 	)
 
 	return req, err
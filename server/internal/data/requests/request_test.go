@@ -1,6 +1,7 @@
 package requests
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
 	"os"
@@ -18,14 +19,18 @@ var testExampleRequest = &Request{
 	ProjectID:  uuid.New().String(),
 	ResponseID: uuid.New().String(),
 	Method:     "GET",
+	Scheme:     "http",
 	Domain:     "localhost",
 	IPAddr:     "127.0.0.1",
+	Upstream:   "socks5://127.0.0.1:1080",
 	URL:        "/",
+	Protocol:   "HTTP/1.1",
 	Length:     18,
 	Edited:     true,
 	Timestamp:  time.Now(),
 	Comment:    "SQL injection.",
-	Raw:        "GET / HTTP/1.1\r\n\r\n",
+	State:      "complete",
+	Raw:        []byte("GET / HTTP/1.1\r\n\r\n"),
 }
 
 func testTable() *RequestsTable {
@@ -89,3 +94,45 @@ func TestRequestFetchById(t *testing.T) {
 
 	fmt.Printf("%+#v\n", fetched)
 }
+
+func TestRequestBinaryRaw(t *testing.T) {
+	table := testTable()
+
+	binary := *testExampleRequest
+	binary.ID = uuid.New().String()
+	binary.Raw = []byte("POST / HTTP/1.1\r\nContent-Length: 4\r\n\r\n\x00\xff\xfe\x89")
+
+	if _, err := table.Insert(&binary); err != nil {
+		t.Fatal(err)
+	}
+
+	fetched, err := table.FetchById(binary.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(fetched.Raw, binary.Raw) {
+		t.Fatalf("fatal: %q inserted, %q fetched.\n", binary.Raw, fetched.Raw)
+	}
+}
+
+func TestRequestOriginal(t *testing.T) {
+	table := testTable()
+
+	edited := *testExampleRequest
+	edited.ID = uuid.New().String()
+	edited.Original = []byte("GET /admin HTTP/1.1\r\n\r\n")
+
+	if _, err := table.Insert(&edited); err != nil {
+		t.Fatal(err)
+	}
+
+	fetched, err := table.FetchById(edited.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(fetched.Original, edited.Original) || !bytes.Equal(fetched.Raw, edited.Raw) {
+		t.Fatalf("fatal: %q and %q inserted, %q and %q fetched.\n", edited.Original, edited.Raw, fetched.Original, fetched.Raw)
+	}
+}
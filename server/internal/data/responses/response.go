@@ -14,11 +14,19 @@ type Response struct {
 	Status    int16     `json:"status"`    // HTTP status code of the response.
 	Length    int64     `json:"length"`    // Length of the response in bytes.
 	Elapsed   int64     `json:"elapsed"`   // Time elapsed since request was sent until response.
+	DNSTime   int64     `json:"dnsTime"`   // Time taken to resolve the target's hostname.
+	Connect   int64     `json:"connect"`   // Time taken to open the TCP connection to the target.
+	TLSTime   int64     `json:"tlsTime"`   // Time taken by the TLS handshake with the target.
+	TTFB      int64     `json:"ttfb"`      // Time from sending the request until the first response byte.
+	Total     int64     `json:"total"`     // Time from connecting until the response was fully read.
 	Edited    bool      `json:"edited"`    // Flag for whether the response was modified or not.
 	Timestamp time.Time `json:"timestamp"` // Time the response was received.
 	Mimetype  string    `json:"mimetype"`  // Mime-type of the response body data.
 	Comment   string    `json:"comment"`   // User-supplied comment on the response.
-	Raw       string    `json:"raw"`       // Raw response bytes.
+	Raw       []byte    `json:"raw"`       // Raw response bytes as forwarded, base64-encoded in JSON.
+	Original  []byte    `json:"original"`  // Raw response bytes before they were edited, if they were.
+	Body      []byte    `json:"-"`         // Body with its transfer and content codings removed.
+	BodyError string    `json:"bodyError"` // Error that prevented the body from being decoded.
 }
 
 type ResponseTable struct {
@@ -39,10 +47,19 @@ func (t ResponseTable) Create() (err error) {
 			status INTEGER NOT NULL,
 			length INTEGER NOT NULL,
 			elapsed INTEGER NOT NULL,
+			dnstime INTEGER NOT NULL,
+			connect INTEGER NOT NULL,
+			tlstime INTEGER NOT NULL,
+			ttfb INTEGER NOT NULL,
+			total INTEGER NOT NULL,
 			edited BOOLEAN NOT NULL CHECK (edited IN (0, 1)),
 			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
 			comment TEXT,
-			raw TEXT NOT NULL
+			mimetype TEXT NOT NULL,
+			raw BLOB NOT NULL,
+			original BLOB,
+			body BLOB,
+			bodyerror TEXT NOT NULL
 		);
 	`)
 
@@ -65,12 +82,21 @@ func (t ResponseTable) Insert(resp *Response) (rowid int64, err error) {
 			status,
 			length,
 			elapsed,
+			dnstime,
+			connect,
+			tlstime,
+			ttfb,
+			total,
 			edited,
 			timestamp,
 			comment,
-			raw
+			mimetype,
+			raw,
+			original,
+			body,
+			bodyerror
 		) VALUES (
-			?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+			?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
 		);
 	`)
 	if err != nil {
@@ -84,10 +110,19 @@ func (t ResponseTable) Insert(resp *Response) (rowid int64, err error) {
 		resp.Status,
 		resp.Length,
 		resp.Elapsed,
+		resp.DNSTime,
+		resp.Connect,
+		resp.TLSTime,
+		resp.TTFB,
+		resp.Total,
 		resp.Edited,
 		resp.Timestamp,
 		resp.Comment,
+		resp.Mimetype,
 		resp.Raw,
+		resp.Original,
+		resp.Body,
+		resp.BodyError,
 	)
 	if err != nil {
 		return 0, err
@@ -117,10 +152,19 @@ func (t ResponseTable) InsertAndFetch(r *Response) (resp *Response, err error) {
 			status,
 			length,
 			elapsed,
+			dnstime,
+			connect,
+			tlstime,
+			ttfb,
+			total,
 			edited,
 			timestamp,
 			comment,
-			raw
+			mimetype,
+			raw,
+			original,
+			body,
+			bodyerror
 		FROM
 			responses
 		WHERE
@@ -140,10 +184,19 @@ func (t ResponseTable) InsertAndFetch(r *Response) (resp *Response, err error) {
 		&resp.Status,
 		&resp.Length,
 		&resp.Elapsed,
+		&resp.DNSTime,
+		&resp.Connect,
+		&resp.TLSTime,
+		&resp.TTFB,
+		&resp.Total,
 		&resp.Edited,
 		&resp.Timestamp,
 		&resp.Comment,
+		&resp.Mimetype,
 		&resp.Raw,
+		&resp.Original,
+		&resp.Body,
+		&resp.BodyError,
 	)
 
 	return resp, err
@@ -162,10 +215,19 @@ func (t ResponseTable) FetchById(id string) (resp *Response, err error) {
 			status,
 			length,
 			elapsed,
+			dnstime,
+			connect,
+			tlstime,
+			ttfb,
+			total,
 			edited,
 			timestamp,
 			comment,
-			raw
+			mimetype,
+			raw,
+			original,
+			body,
+			bodyerror
 		FROM
 			responses	
 		WHERE
@@ -184,10 +246,19 @@ func (t ResponseTable) FetchById(id string) (resp *Response, err error) {
 		&resp.Status,
 		&resp.Length,
 		&resp.Elapsed,
+		&resp.DNSTime,
+		&resp.Connect,
+		&resp.TLSTime,
+		&resp.TTFB,
+		&resp.Total,
 		&resp.Edited,
 		&resp.Timestamp,
 		&resp.Comment,
+		&resp.Mimetype,
 		&resp.Raw,
+		&resp.Original,
+		&resp.Body,
+		&resp.BodyError,
 	)
 
 	return resp, err
@@ -24,7 +24,8 @@ var testExampleResponse = &Response{
 	Timestamp: time.Now(),
 	Mimetype:  "text/html",
 	Comment:   "SQL injection.",
-	Raw:       "HTTP/1.0 200 OK\r\nServer: SimpleHTTP/0.6 Python/3.10.1\r\nDate: Fri, 17 Dec 2021 10:45:06 GMT\r\nContent-type: text/html; charset=utf-8\r\nContent-Length: 0\r\n\r\n",
+	Body:      []byte("<html></html>"),
+	Raw:       []byte("HTTP/1.0 200 OK\r\nServer: SimpleHTTP/0.6 Python/3.10.1\r\nDate: Fri, 17 Dec 2021 10:45:06 GMT\r\nContent-type: text/html; charset=utf-8\r\nContent-Length: 0\r\n\r\n"),
 }
 
 func testTable() *ResponseTable {
@@ -86,5 +87,9 @@ func TestResponseFetchById(t *testing.T) {
 		t.Fatalf("fatal: inserted ID (%s) does not match fetched ID (%s).\n", inserted.ID, fetched.ID)
 	}
 
+	if inserted.Mimetype != fetched.Mimetype || string(inserted.Body) != string(fetched.Body) {
+		t.Fatalf("fatal: fetched body does not match inserted body: %+v\n", fetched)
+	}
+
 	fmt.Printf("%+#v\n", fetched)
 }
@@ -0,0 +1,403 @@
+package scope
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Actions of scope rules.
+const (
+	ActionInclude = "include" // Targets matching the rule are in scope
+	ActionExclude = "exclude" // Targets matching the rule are out of scope
+)
+
+var (
+	ErrInvalidAction = errors.New("action must be include or exclude")
+	ErrInvalidScheme = errors.New("scheme must be http, https or empty")
+	ErrInvalidPort   = errors.New("port must be between 0 and 65535")
+)
+
+// Rule includes targets in a project's scope or excludes them from it. A target
+// is in scope if it matches an include rule and no exclude rule. Every target is
+// in scope while a project has no include rules. Empty fields match any target.
+// The host is matched as a whole hostname, ignoring case, unless it is a regular
+// expression, which matches hostnames that contain a match.
+type Rule struct {
+	ID         string `json:"id"`         // Unique ID of the rule.
+	ProjectID  string `json:"projectId"`  // Unique ID of the parent project.
+	Enabled    bool   `json:"enabled"`    // Flag for whether the rule is applied.
+	Action     string `json:"action"`     // Whether matching targets are included or excluded.
+	Scheme     string `json:"scheme"`     // URL scheme of the target (http or https).
+	Host       string `json:"host"`       // Hostname of the target, or a pattern matching it.
+	Regex      bool   `json:"regex"`      // Flag for whether the host is a regular expression.
+	Port       int    `json:"port"`       // Port of the target, or 0 for any port.
+	PathPrefix string `json:"pathPrefix"` // Prefix of the paths of the target.
+}
+
+// Validate checks that a rule can be applied.
+func (r *Rule) Validate() error {
+	if r.Action != ActionInclude && r.Action != ActionExclude {
+		return ErrInvalidAction
+	}
+
+	if r.Scheme != "" && r.Scheme != "http" && r.Scheme != "https" {
+		return ErrInvalidScheme
+	}
+
+	if r.Port < 0 || r.Port > 65535 {
+		return ErrInvalidPort
+	}
+
+	if !r.Regex {
+		return nil
+	}
+
+	_, err := regexp.Compile(r.Host)
+	return err
+}
+
+// compiledRule is a rule with its host pattern compiled.
+type compiledRule struct {
+	Rule
+	host *regexp.Regexp // Pattern matching the hostname of the target
+}
+
+// Scope is the compiled set of a project's enabled scope rules.
+type Scope struct {
+	include []compiledRule // Rules including targets in scope
+	exclude []compiledRule // Rules excluding targets from scope
+}
+
+// Compile compiles the enabled rules of a project into its scope. A rule that
+// fails to compile is skipped.
+func Compile(rules []Rule) *Scope {
+	s := &Scope{}
+
+	for _, r := range rules {
+		var err error
+
+		if !r.Enabled || r.Validate() != nil {
+			continue
+		}
+
+		c := compiledRule{Rule: r}
+		if c.host, err = regexp.Compile(hostPattern(r)); err != nil {
+			continue
+		}
+
+		if r.Action == ActionInclude {
+			s.include = append(s.include, c)
+		} else {
+			s.exclude = append(s.exclude, c)
+		}
+	}
+
+	return s
+}
+
+// hostPattern returns the pattern a rule matches hostnames with. A literal host
+// only matches the whole hostname, ignoring case.
+func hostPattern(r Rule) string {
+	if r.Regex || r.Host == "" {
+		return r.Host
+	}
+
+	return `(?i)^` + regexp.QuoteMeta(r.Host) + `$`
+}
+
+// port returns the port of a URL, or the default port of its scheme.
+func port(u *url.URL) int {
+	if p, err := strconv.Atoi(u.Port()); err == nil {
+		return p
+	}
+
+	if u.Scheme == "https" {
+		return 443
+	}
+
+	return 80
+}
+
+// match reports whether the rule matches the target of a URL.
+func (r *compiledRule) match(u *url.URL) bool {
+	if r.Scheme != "" && r.Scheme != u.Scheme {
+		return false
+	}
+
+	if r.Port != 0 && r.Port != port(u) {
+		return false
+	}
+
+	return r.host.MatchString(u.Hostname()) && strings.HasPrefix(u.Path, r.PathPrefix)
+}
+
+// Contains reports whether a URL matches an include rule and no exclude rule.
+// Every URL is in scope when there are no include rules.
+func (s *Scope) Contains(u *url.URL) bool {
+	if s == nil {
+		return true
+	}
+
+	for i := range s.exclude {
+		if s.exclude[i].match(u) {
+			return false
+		}
+	}
+
+	if len(s.include) == 0 {
+		return true
+	}
+
+	for i := range s.include {
+		if s.include[i].match(u) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type RulesTable struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *RulesTable {
+	return &RulesTable{db}
+}
+
+// Create creates the "scope_rules" table if it doesn't already exist.
+func (t RulesTable) Create() (err error) {
+	_, err = t.db.Exec(`
+		CREATE TABLE IF NOT EXISTS scope_rules (
+			id TEXT PRIMARY KEY NOT NULL UNIQUE,
+			projectid TEXT NOT NULL,
+			enabled BOOLEAN NOT NULL CHECK (enabled IN (0, 1)),
+			action TEXT NOT NULL,
+			scheme TEXT NOT NULL,
+			host TEXT NOT NULL,
+			regex BOOLEAN NOT NULL CHECK (regex IN (0, 1)),
+			port INTEGER NOT NULL,
+			pathprefix TEXT NOT NULL
+		);
+	`)
+
+	return err
+}
+
+// Insert inserts a new rule.
+func (t RulesTable) Insert(r *Rule) (err error) {
+	var stmt *sql.Stmt
+
+	stmt, err = t.db.Prepare(`
+		INSERT INTO scope_rules(
+			id,
+			projectid,
+			enabled,
+			action,
+			scheme,
+			host,
+			regex,
+			port,
+			pathprefix
+		) VALUES (
+			?, ?, ?, ?, ?, ?, ?, ?, ?
+		);
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	r.ID = uuid.New().String()
+
+	_, err = stmt.Exec(
+		r.ID,
+		r.ProjectID,
+		r.Enabled,
+		r.Action,
+		r.Scheme,
+		r.Host,
+		r.Regex,
+		r.Port,
+		r.PathPrefix,
+	)
+
+	return err
+}
+
+// Update replaces the fields of an existing rule.
+func (t RulesTable) Update(r *Rule) (err error) {
+	var (
+		stmt *sql.Stmt
+		res  sql.Result
+		n    int64
+	)
+
+	stmt, err = t.db.Prepare(`
+		UPDATE
+			scope_rules
+		SET
+			enabled = ?,
+			action = ?,
+			scheme = ?,
+			host = ?,
+			regex = ?,
+			port = ?,
+			pathprefix = ?
+		WHERE
+			id = ?;
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	res, err = stmt.Exec(
+		r.Enabled,
+		r.Action,
+		r.Scheme,
+		r.Host,
+		r.Regex,
+		r.Port,
+		r.PathPrefix,
+		r.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if n, err = res.RowsAffected(); err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Delete deletes a rule.
+func (t RulesTable) Delete(id string) (err error) {
+	var (
+		res sql.Result
+		n   int64
+	)
+
+	if res, err = t.db.Exec(`DELETE FROM scope_rules WHERE id = ?;`, id); err != nil {
+		return err
+	}
+
+	if n, err = res.RowsAffected(); err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// scan scans a rule from a row.
+func scan(row interface{ Scan(...interface{}) error }) (*Rule, error) {
+	r := &Rule{}
+
+	err := row.Scan(
+		&r.ID,
+		&r.ProjectID,
+		&r.Enabled,
+		&r.Action,
+		&r.Scheme,
+		&r.Host,
+		&r.Regex,
+		&r.Port,
+		&r.PathPrefix,
+	)
+
+	return r, err
+}
+
+// Fetch returns the scope rules of a project.
+func (t RulesTable) Fetch(projectId string) (rules []Rule, err error) {
+	var (
+		stmt *sql.Stmt
+		rows *sql.Rows
+	)
+
+	stmt, err = t.db.Prepare(`
+		SELECT
+			id,
+			projectid,
+			enabled,
+			action,
+			scheme,
+			host,
+			regex,
+			port,
+			pathprefix
+		FROM
+			scope_rules
+		WHERE
+			projectid = ?
+		ORDER BY
+			rowid;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	if rows, err = stmt.Query(projectId); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules = make([]Rule, 0)
+
+	for rows.Next() {
+		r, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, *r)
+	}
+
+	return rules, rows.Err()
+}
+
+// FetchById returns a single rule by its id.
+func (t RulesTable) FetchById(id string) (r *Rule, err error) {
+	var stmt *sql.Stmt
+
+	stmt, err = t.db.Prepare(`
+		SELECT
+			id,
+			projectid,
+			enabled,
+			action,
+			scheme,
+			host,
+			regex,
+			port,
+			pathprefix
+		FROM
+			scope_rules
+		WHERE
+			id = ?
+		LIMIT 0, 1;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	return scan(stmt.QueryRow(id))
+}
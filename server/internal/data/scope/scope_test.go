@@ -0,0 +1,111 @@
+package scope
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+)
+
+const DatabasePath = "/tmp/db.sqlite"
+
+var testExampleRule = &Rule{
+	ProjectID:  uuid.New().String(),
+	Enabled:    true,
+	Action:     ActionInclude,
+	Scheme:     "https",
+	Host:       `(^|\.)example\.com$`,
+	Regex:      true,
+	Port:       443,
+	PathPrefix: "/api/",
+}
+
+func testTable() *RulesTable {
+	file, err := os.Create(DatabasePath)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	db, err := sql.Open("sqlite", DatabasePath)
+	if err != nil {
+		panic(err)
+	}
+
+	table := &RulesTable{db}
+	if err = table.Create(); err != nil {
+		panic(err)
+	}
+
+	return table
+}
+
+func TestRuleValidate(t *testing.T) {
+	invalid := []Rule{
+		{Action: "allow"},
+		{Action: ActionInclude, Scheme: "ftp"},
+		{Action: ActionInclude, Port: 70000},
+		{Action: ActionExclude, Host: "(", Regex: true},
+	}
+
+	for _, r := range invalid {
+		if err := r.Validate(); err == nil {
+			t.Fatalf("fatal: invalid rule passed validation: %+v\n", r)
+		}
+	}
+
+	if err := testExampleRule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRuleInsertAndFetch(t *testing.T) {
+	table := testTable()
+
+	if err := table.Insert(testExampleRule); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := table.Fetch(testExampleRule.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rules) != 1 || rules[0] != *testExampleRule {
+		t.Fatalf("fatal: unexpected rules fetched: %+v\n", rules)
+	}
+}
+
+func TestRuleUpdateAndDelete(t *testing.T) {
+	table := testTable()
+
+	if err := table.Insert(testExampleRule); err != nil {
+		t.Fatal(err)
+	}
+
+	updated := *testExampleRule
+	updated.Action = ActionExclude
+
+	if err := table.Update(&updated); err != nil {
+		t.Fatal(err)
+	}
+
+	fetched, err := table.FetchById(updated.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fetched.Action != ActionExclude {
+		t.Fatalf("fatal: action %q expected, %q fetched.\n", ActionExclude, fetched.Action)
+	}
+
+	if err = table.Delete(updated.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = table.FetchById(updated.ID); err != sql.ErrNoRows {
+		t.Fatalf("fatal: deleted rule was fetched: %v\n", err)
+	}
+}
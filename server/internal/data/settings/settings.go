@@ -0,0 +1,180 @@
+package settings
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+const (
+	DefaultBindAddress = "0.0.0.0" // Listen on all interfaces by default
+	DefaultPort        = 8080      // Default proxy listener port
+	DefaultMode        = "http"    // Default proxy listener mode
+	DefaultDropAction  = "close"   // Close the client connection when an item is dropped
+	DefaultDropStatus  = 403       // Status of the page sent for dropped items
+)
+
+// Listener is the address a proxy listener is bound to.
+type Listener struct {
+	BindAddress string `json:"bindAddress"` // Address of the interface to listen on.
+	Port        int    `json:"port"`        // Port to listen on.
+	Mode        string `json:"mode"`        // Protocol clients speak to the listener, http if empty.
+	Target      string `json:"target"`      // Origin requests are forwarded to by reverse listeners.
+	HostHeader  string `json:"hostHeader"`  // Host header sent to the target by reverse listeners, if rewritten.
+}
+
+// Settings represents the proxy configuration of a project.
+type Settings struct {
+	ProjectID       string     `json:"projectId"`       // Unique ID of the project.
+	Listeners       []Listener `json:"listeners"`       // Proxy listeners.
+	InterceptClient bool       `json:"interceptClient"` // Intercept client HTTP requests.
+	InterceptServer bool       `json:"interceptServer"` // Intercept server HTTP responses.
+	Stall           bool       `json:"stall"`           // Stall requests and responses.
+	PassOutOfScope  bool       `json:"passOutOfScope"`  // Pass out-of-scope traffic through without logging or interception.
+	DropAction      string     `json:"dropAction"`      // Default action taken when an item is dropped.
+	DropStatus      int        `json:"dropStatus"`      // Default status of the page sent for dropped items.
+	FixFraming      bool       `json:"fixFraming"`      // Fix the line endings and framing of edited items.
+}
+
+// Default returns the settings of a project that hasn't changed them.
+func Default(projectId string) *Settings {
+	return &Settings{
+		ProjectID: projectId,
+		Listeners: []Listener{
+			{BindAddress: DefaultBindAddress, Port: DefaultPort, Mode: DefaultMode},
+		},
+		InterceptClient: true,
+		InterceptServer: true,
+		Stall:           false,
+		DropAction:      DefaultDropAction,
+		DropStatus:      DefaultDropStatus,
+	}
+}
+
+type SettingsTable struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *SettingsTable {
+	return &SettingsTable{db}
+}
+
+// Create creates the "project_settings" table if it doesn't already exist.
+func (t SettingsTable) Create() (err error) {
+	_, err = t.db.Exec(`
+		CREATE TABLE IF NOT EXISTS project_settings (
+			projectid TEXT PRIMARY KEY NOT NULL UNIQUE,
+			listeners TEXT NOT NULL,
+			interceptclient BOOLEAN NOT NULL CHECK (interceptclient IN (0, 1)),
+			interceptserver BOOLEAN NOT NULL CHECK (interceptserver IN (0, 1)),
+			stall BOOLEAN NOT NULL CHECK (stall IN (0, 1)),
+			passoutofscope BOOLEAN NOT NULL CHECK (passoutofscope IN (0, 1)),
+			dropaction TEXT NOT NULL,
+			dropstatus INTEGER NOT NULL,
+			fixframing BOOLEAN NOT NULL CHECK (fixframing IN (0, 1))
+		);
+	`)
+
+	return err
+}
+
+// Save inserts or replaces the settings of a project.
+func (t SettingsTable) Save(s *Settings) (err error) {
+	var (
+		stmt      *sql.Stmt
+		listeners []byte
+	)
+
+	stmt, err = t.db.Prepare(`
+		INSERT OR REPLACE INTO project_settings(
+			projectid,
+			listeners,
+			interceptclient,
+			interceptserver,
+			stall,
+			passoutofscope,
+			dropaction,
+			dropstatus,
+			fixframing
+		) VALUES (
+			?, ?, ?, ?, ?, ?, ?, ?, ?
+		);
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	if listeners, err = json.Marshal(s.Listeners); err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(
+		s.ProjectID,
+		string(listeners),
+		s.InterceptClient,
+		s.InterceptServer,
+		s.Stall,
+		s.PassOutOfScope,
+		s.DropAction,
+		s.DropStatus,
+		s.FixFraming,
+	)
+
+	return err
+}
+
+// Fetch returns the settings of a project. Projects that haven't saved any
+// settings are given the default settings.
+func (t SettingsTable) Fetch(projectId string) (s *Settings, err error) {
+	var (
+		stmt      *sql.Stmt
+		listeners string
+	)
+
+	stmt, err = t.db.Prepare(`
+		SELECT
+			projectid,
+			listeners,
+			interceptclient,
+			interceptserver,
+			stall,
+			passoutofscope,
+			dropaction,
+			dropstatus,
+			fixframing
+		FROM
+			project_settings
+		WHERE
+			projectid = ?
+		LIMIT 0, 1;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	s = &Settings{}
+	err = stmt.QueryRow(projectId).Scan(
+		&s.ProjectID,
+		&listeners,
+		&s.InterceptClient,
+		&s.InterceptServer,
+		&s.Stall,
+		&s.PassOutOfScope,
+		&s.DropAction,
+		&s.DropStatus,
+		&s.FixFraming,
+	)
+	if err == sql.ErrNoRows {
+		return Default(projectId), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal([]byte(listeners), &s.Listeners); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
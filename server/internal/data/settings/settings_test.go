@@ -0,0 +1,88 @@
+package settings
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+)
+
+const DatabasePath = "/tmp/db.sqlite"
+
+var testExampleSettings = &Settings{
+	ProjectID: uuid.New().String(),
+	Listeners: []Listener{
+		{BindAddress: "127.0.0.1", Port: 8081},
+		{BindAddress: "0.0.0.0", Port: 8082, Mode: "socks5"},
+	},
+	InterceptClient: true,
+	InterceptServer: false,
+	Stall:           true,
+	DropAction:      "status",
+	DropStatus:      502,
+	FixFraming:      true,
+}
+
+func testTable() *SettingsTable {
+	file, err := os.Create(DatabasePath)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	db, err := sql.Open("sqlite", DatabasePath)
+	if err != nil {
+		panic(err)
+	}
+
+	table := &SettingsTable{db}
+	if err = table.Create(); err != nil {
+		panic(err)
+	}
+
+	return table
+}
+
+func TestSettingsFetchDefault(t *testing.T) {
+	table := testTable()
+	projectId := uuid.New().String()
+
+	fetched, err := table.Fetch(projectId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fetched.ProjectID != projectId || len(fetched.Listeners) != 1 {
+		t.Fatalf("fatal: unexpected default settings: %+v\n", fetched)
+	}
+}
+
+func TestSettingsSave(t *testing.T) {
+	table := testTable()
+
+	if err := table.Save(testExampleSettings); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving again replaces the existing settings.
+	if err := table.Save(testExampleSettings); err != nil {
+		t.Fatal(err)
+	}
+
+	fetched, err := table.Fetch(testExampleSettings.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fetched.Listeners) != len(testExampleSettings.Listeners) {
+		t.Fatalf("fatal: %d listeners expected, %d listeners fetched.\n",
+			len(testExampleSettings.Listeners), len(fetched.Listeners))
+	}
+
+	if fetched.Listeners[1] != testExampleSettings.Listeners[1] || !fetched.Stall ||
+		fetched.DropAction != testExampleSettings.DropAction || fetched.DropStatus != testExampleSettings.DropStatus || !fetched.FixFraming {
+		t.Fatalf("fatal: fetched settings do not match saved settings: %+v\n", fetched)
+	}
+}
@@ -0,0 +1,221 @@
+// Package diff computes the differences between two versions of a message,
+// either line by line or byte by byte.
+package diff
+
+import (
+	"bytes"
+	"errors"
+)
+
+// MaxChanges limits the number of tokens inserted or deleted between two
+// versions. The Myers algorithm takes memory quadratic in that number.
+const MaxChanges = 1000
+
+var ErrTooManyChanges = errors.New("too many changes to compute a diff")
+
+// Op is the kind of an edit.
+type Op string
+
+const (
+	OpEqual  Op = "equal"  // The data is in both versions
+	OpInsert Op = "insert" // The data is only in the new version
+	OpDelete Op = "delete" // The data is only in the old version
+)
+
+// Edit is a run of data that is equal in both versions, inserted or deleted.
+type Edit struct {
+	Op   Op     // Kind of the edit
+	Old  int    // Offset of the edit in the old version, in lines or bytes
+	New  int    // Offset of the edit in the new version, in lines or bytes
+	Data []byte // Data that is kept, inserted or deleted
+}
+
+// Lines returns the edits turning a into b, comparing them line by line. Lines
+// keep their line endings, and offsets are counted in lines.
+func Lines(a, b []byte) ([]Edit, error) {
+	return diff(split(a), split(b))
+}
+
+// Bytes returns the edits turning a into b, comparing them byte by byte.
+func Bytes(a, b []byte) ([]Edit, error) {
+	return diff(bytesOf(a), bytesOf(b))
+}
+
+// bytesOf splits data into single bytes. Unlike bytes.Split, UTF-8 sequences
+// are not kept together, so that offsets are counted in bytes.
+func bytesOf(data []byte) [][]byte {
+	toks := make([][]byte, 0, len(data))
+	for i := range data {
+		toks = append(toks, data[i:i+1])
+	}
+
+	return toks
+}
+
+// split splits data into lines, keeping the line endings.
+func split(data []byte) [][]byte {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+
+	// A final line ending leaves an empty line behind it.
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// diff returns the edits turning the tokens of a into the tokens of b. The
+// common prefix and suffix are trimmed before the rest is compared.
+func diff(a, b [][]byte) ([]Edit, error) {
+	var (
+		prefix int
+		suffix int
+		ops    []Op
+		edits  []Edit
+		err    error
+	)
+
+	for prefix < len(a) && prefix < len(b) && bytes.Equal(a[prefix], b[prefix]) {
+		prefix++
+	}
+
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		bytes.Equal(a[len(a)-1-suffix], b[len(b)-1-suffix]) {
+		suffix++
+	}
+
+	if ops, err = myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < prefix; i++ {
+		edits = appendOp(edits, OpEqual, i, i, a[i])
+	}
+
+	x, y := prefix, prefix
+	for _, op := range ops {
+		switch op {
+		case OpEqual:
+			edits = appendOp(edits, op, x, y, a[x])
+			x, y = x+1, y+1
+		case OpDelete:
+			edits = appendOp(edits, op, x, y, a[x])
+			x++
+		case OpInsert:
+			edits = appendOp(edits, op, x, y, b[y])
+			y++
+		}
+	}
+
+	for ; x < len(a); x, y = x+1, y+1 {
+		edits = appendOp(edits, OpEqual, x, y, a[x])
+	}
+
+	return edits, nil
+}
+
+// appendOp adds a token to the last edit if it has the same kind, or starts a
+// new edit at the given offsets.
+func appendOp(edits []Edit, op Op, old, new int, token []byte) []Edit {
+	if n := len(edits); n > 0 && edits[n-1].Op == op {
+		edits[n-1].Data = append(edits[n-1].Data, token...)
+		return edits
+	}
+
+	return append(edits, Edit{
+		Op:   op,
+		Old:  old,
+		New:  new,
+		Data: append([]byte(nil), token...),
+	})
+}
+
+// myers returns the shortest sequence of operations turning a into b, using
+// the algorithm from Eugene W. Myers' "An O(ND) Difference Algorithm and Its
+// Variations".
+func myers(a, b [][]byte) ([]Op, error) {
+	var (
+		n, m   = len(a), len(b)
+		offset = n + m + 1
+		v      = make([]int, 2*offset+1)
+		trace  [][]int
+	)
+
+	for d := 0; d <= n+m; d++ {
+		if d > MaxChanges {
+			return nil, ErrTooManyChanges
+		}
+
+		// Keep the furthest points reached with d-1 changes for backtracking.
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && bytes.Equal(a[x], b[y]) {
+				x, y = x+1, y+1
+			}
+
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(trace, n, m), nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// backtrack follows the furthest points back from the end of both sequences
+// and returns the operations on the path in order.
+func backtrack(trace [][]int, x, y int) []Op {
+	var ops []Op
+
+	for d := len(trace) - 1; d > 0; d-- {
+		var (
+			v     = trace[d]
+			k     = x - y
+			prevK int
+		)
+
+		if k == -d || (k != d && v[k-1+d] < v[k+1+d]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+
+		prevX := v[prevK+d]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			ops = append(ops, OpEqual)
+			x, y = x-1, y-1
+		}
+
+		if x == prevX {
+			ops = append(ops, OpInsert)
+		} else {
+			ops = append(ops, OpDelete)
+		}
+
+		x, y = prevX, prevY
+	}
+
+	for ; x > 0 && y > 0; x, y = x-1, y-1 {
+		ops = append(ops, OpEqual)
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+
+	return ops
+}
@@ -0,0 +1,110 @@
+package diff
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// testApply rebuilds both versions from a list of edits.
+func testApply(edits []Edit) (a, b []byte) {
+	for _, e := range edits {
+		if e.Op != OpInsert {
+			a = append(a, e.Data...)
+		}
+
+		if e.Op != OpDelete {
+			b = append(b, e.Data...)
+		}
+	}
+
+	return a, b
+}
+
+func TestLines(t *testing.T) {
+	a := []byte("GET / HTTP/1.1\r\nHost: localhost\r\nCookie: admin=0\r\n\r\n")
+	b := []byte("GET / HTTP/1.1\r\nHost: localhost\r\nCookie: admin=1\r\nX-Test: 1\r\n\r\n")
+
+	edits, err := Lines(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Edit{
+		{Op: OpEqual, Old: 0, New: 0, Data: []byte("GET / HTTP/1.1\r\nHost: localhost\r\n")},
+		{Op: OpDelete, Old: 2, New: 2, Data: []byte("Cookie: admin=0\r\n")},
+		{Op: OpInsert, Old: 3, New: 2, Data: []byte("Cookie: admin=1\r\nX-Test: 1\r\n")},
+		{Op: OpEqual, Old: 3, New: 4, Data: []byte("\r\n")},
+	}
+
+	if len(edits) != len(expected) {
+		t.Fatalf("fatal: %d edits expected, %d returned: %+v\n", len(expected), len(edits), edits)
+	}
+
+	for i := range edits {
+		e, x := edits[i], expected[i]
+		if e.Op != x.Op || e.Old != x.Old || e.New != x.New || !bytes.Equal(e.Data, x.Data) {
+			t.Fatalf("fatal: edit %d: %+v expected, %+v returned.\n", i, x, e)
+		}
+	}
+}
+
+func TestBytesOffsets(t *testing.T) {
+	edits, err := Bytes([]byte("héllo x"), []byte("héllo y"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Edit{
+		{Op: OpEqual, Old: 0, New: 0, Data: []byte("héllo ")},
+		{Op: OpDelete, Old: 7, New: 7, Data: []byte("x")},
+		{Op: OpInsert, Old: 8, New: 7, Data: []byte("y")},
+	}
+
+	if len(edits) != len(expected) {
+		t.Fatalf("fatal: %d edits expected, %d returned: %+v\n", len(expected), len(edits), edits)
+	}
+
+	for i := range edits {
+		e, x := edits[i], expected[i]
+		if e.Op != x.Op || e.Old != x.Old || e.New != x.New || !bytes.Equal(e.Data, x.Data) {
+			t.Fatalf("fatal: edit %d: %+v expected, %+v returned.\n", i, x, e)
+		}
+	}
+}
+
+func TestBytesRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 200; i++ {
+		a := make([]byte, rng.Intn(64))
+		b := make([]byte, rng.Intn(64))
+		rng.Read(a)
+		rng.Read(b)
+
+		// Share some bytes, so that the versions have something in common.
+		for j := range b {
+			if j < len(a) && rng.Intn(2) == 0 {
+				b[j] = a[j]
+			}
+		}
+
+		edits, err := Bytes(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if gotA, gotB := testApply(edits); !bytes.Equal(gotA, a) || !bytes.Equal(gotB, b) {
+			t.Fatalf("fatal: edits do not rebuild %x and %x: %+v\n", a, b, edits)
+		}
+	}
+}
+
+func TestTooManyChanges(t *testing.T) {
+	a := bytes.Repeat([]byte("a"), MaxChanges)
+	b := bytes.Repeat([]byte("b"), MaxChanges)
+
+	if _, err := Bytes(a, b); err != ErrTooManyChanges {
+		t.Fatalf("fatal: %v expected, %v returned.\n", ErrTooManyChanges, err)
+	}
+}
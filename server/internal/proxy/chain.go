@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+
+	"github.com/ihaxolotl/webproxy/internal/data/chain"
+)
+
+// chainRule is an upstream proxy rule with its host pattern compiled.
+type chainRule struct {
+	chain.Rule
+	host *regexp.Regexp // Pattern matching the hostname of the target
+}
+
+// compileChain compiles the enabled upstream proxy rules of a project, in the
+// order they are evaluated. A rule that fails to compile is skipped.
+func compileChain(rules []chain.Rule) []chainRule {
+	var compiled []chainRule
+
+	for _, r := range rules {
+		var err error
+
+		if !r.Enabled || r.Validate() != nil {
+			continue
+		}
+
+		c := chainRule{Rule: r}
+		if c.host, err = regexp.Compile(r.Host); err != nil {
+			continue
+		}
+
+		compiled = append(compiled, c)
+	}
+
+	return compiled
+}
+
+// via returns the rule of the upstream proxy that connections to a hostname are
+// made through, or nil if they are made directly.
+func (set *ruleset) via(hostname string) *chain.Rule {
+	if set == nil {
+		return nil
+	}
+
+	for i := range set.chain {
+		if !set.chain[i].host.MatchString(hostname) {
+			continue
+		}
+
+		if set.chain[i].Protocol == chain.ProtocolDirect {
+			return nil
+		}
+
+		return &set.chain[i].Rule
+	}
+
+	return nil
+}
+
+// proxyURL returns the upstream proxy of a rule as it is recorded on exchanges.
+// Credentials are left out.
+func proxyURL(r *chain.Rule) string {
+	if r == nil {
+		return ""
+	}
+
+	return r.Protocol + "://" + r.Address
+}
+
+// proxyError is returned by dial when the upstream proxy can't be reached, or
+// can't open a connection to the target server.
+type proxyError struct {
+	proxy string
+	err   error
+}
+
+func (e *proxyError) Error() string {
+	return "upstream proxy " + e.proxy + ": " + e.err.Error()
+}
+
+func (e *proxyError) Unwrap() error {
+	return e.err
+}
+
+// httpConnect asks the HTTP proxy at the other end of conn to open a tunnel to
+// addr with a CONNECT request, authenticating with Basic credentials if a
+// username is given. The returned connection carries the tunnel.
+func httpConnect(conn net.Conn, addr, username, password string) (net.Conn, error) {
+	var (
+		reader   *bufio.Reader
+		res      *http.Response
+		buffered []byte
+		err      error
+	)
+
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
+	if username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		req += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+
+	if _, err = conn.Write([]byte(req + "\r\n")); err != nil {
+		return nil, err
+	}
+
+	reader = bufio.NewReader(conn)
+	if res, err = http.ReadResponse(reader, &http.Request{Method: http.MethodConnect}); err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("CONNECT answered with %s", res.Status)
+	}
+
+	// Bytes the tunnel sent along with the response are kept for the reader.
+	if buffered, err = reader.Peek(reader.Buffered()); err != nil {
+		return nil, err
+	}
+
+	return &bufferedConn{conn, buffered}, nil
+}
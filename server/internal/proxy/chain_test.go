@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ihaxolotl/webproxy/internal/data/chain"
+	"github.com/ihaxolotl/webproxy/internal/data/requests"
+)
+
+// testConnectProxy is an HTTP proxy that only accepts CONNECT requests with the
+// given Proxy-Authorization header.
+func testConnectProxy(t *testing.T, authorization string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.Header.Get("Proxy-Authorization") != authorization {
+			rw.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+
+		conn, _, err := rw.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte(connectEstablished))
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+	}))
+}
+
+// testSOCKSProxy is a SOCKS5 proxy that only accepts the given credentials.
+func testSOCKSProxy(t *testing.T, username, password string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+				buf := make([]byte, 255)
+
+				// Greeting, offering username/password authentication.
+				io.ReadFull(r, buf[:2])
+				io.ReadFull(r, buf[:buf[1]])
+				conn.Write([]byte{socksVersion, socksAuthPassword})
+
+				io.ReadFull(r, buf[:2])
+				user := make([]byte, buf[1])
+				io.ReadFull(r, user)
+				io.ReadFull(r, buf[:1])
+				pass := make([]byte, buf[0])
+				io.ReadFull(r, pass)
+
+				if string(user) != username || string(pass) != password {
+					conn.Write([]byte{socksAuthVersion, 0x01})
+					return
+				}
+				conn.Write([]byte{socksAuthVersion, socksSucceeded})
+
+				io.ReadFull(r, buf[:4])
+				addr, err := readSOCKSAddr(r, buf[3])
+				if err != nil {
+					return
+				}
+
+				target, err := net.Dial("tcp", addr)
+				if err != nil {
+					conn.Write([]byte{socksVersion, 0x05, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer target.Close()
+
+				conn.Write([]byte{socksVersion, socksSucceeded, 0x00, socksAddrIPv4, 127, 0, 0, 1, 0, 0})
+				go io.Copy(target, r)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+
+	return listener
+}
+
+// testDialVia sends a request to a target server through an upstream proxy and
+// returns the status of the response.
+func testDialVia(t *testing.T, target string, via *chain.Rule) (int, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &http.Request{Method: http.MethodGet, URL: u, Header: make(http.Header), Host: u.Host}
+
+	up, err := dial(req, via)
+	if err != nil {
+		return 0, err
+	}
+	defer up.conn.Close()
+
+	if up.via != proxyURL(via) || up.ip != "" {
+		t.Fatalf("fatal: connection through %q recorded as %q with address %q.\n", proxyURL(via), up.via, up.ip)
+	}
+
+	if err = req.Write(up.conn); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.ReadResponse(bufio.NewReader(up.conn), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	return res.StatusCode, nil
+}
+
+func TestRulesetVia(t *testing.T) {
+	set := &ruleset{chain: compileChain([]chain.Rule{
+		{Enabled: true, Host: `^internal\.`, Protocol: chain.ProtocolDirect},
+		{Enabled: false, Host: `.*`, Protocol: chain.ProtocolHTTP, Address: "disabled:8080"},
+		{Enabled: true, Host: `corp\.com$`, Protocol: chain.ProtocolHTTP, Address: "proxy:8080"},
+		{Enabled: true, Host: `.*`, Protocol: chain.ProtocolSOCKS5, Address: "pivot:1080"},
+	})}
+
+	hosts := map[string]string{
+		"internal.corp.com": "",
+		"www.corp.com":      "http://proxy:8080",
+		"example.com":       "socks5://pivot:1080",
+	}
+
+	for host, expected := range hosts {
+		if via := proxyURL(set.via(host)); via != expected {
+			t.Fatalf("fatal: %s: %q expected, %q returned.\n", host, expected, via)
+		}
+	}
+}
+
+func TestDialChain(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	connect := testConnectProxy(t, "Basic dXNlcjpwYXNz")
+	defer connect.Close()
+
+	socks := testSOCKSProxy(t, "user", "pass")
+	defer socks.Close()
+
+	proxies := []chain.Rule{
+		{Protocol: chain.ProtocolHTTP, Address: connect.Listener.Addr().String(), Username: "user", Password: "pass"},
+		{Protocol: chain.ProtocolSOCKS5, Address: socks.Addr().String(), Username: "user", Password: "pass"},
+	}
+
+	for _, via := range proxies {
+		via := via
+
+		status, err := testDialVia(t, server.URL, &via)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if status != http.StatusNotFound {
+			t.Fatalf("fatal: %s: status %d expected, %d returned.\n", via.Protocol, http.StatusNotFound, status)
+		}
+
+		// Wrong credentials are rejected by the proxy.
+		via.Password = "wrong"
+
+		if _, err = testDialVia(t, server.URL, &via); classify(err) != requests.ErrorKindProxy {
+			t.Fatalf("fatal: %s: %q expected, %v returned.\n", via.Protocol, requests.ErrorKindProxy, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Client is a control panel WebSocket connection attached to a proxy. Stalled
+// requests and responses are sent to every attached client, and commands may
+// be received from any of them.
+type Client struct {
+	conn *websocket.Conn // Control panel WebSocket connection
+	mu   sync.Mutex      // Serializes writes to the WebSocket connection
+}
+
+// send writes a command to the client's WebSocket connection.
+func (c *Client) send(payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn.WriteMessage(websocket.TextMessage, payload)
+}
+
+// Send writes a command to the client's WebSocket connection.
+func (c *Client) Send(msg *ProxyCmd) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return c.send(payload)
+}
+
+// close closes the client's WebSocket connection, so that the control panel
+// knows the proxy has stopped.
+func (c *Client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn.WriteMessage(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseGoingAway, ErrProxyNotRunning.Error()),
+	)
+	c.conn.Close()
+}
+
+// Attach attaches a control panel WebSocket connection to the proxy.
+func (proxy *Proxy) Attach(conn *websocket.Conn) *Client {
+	proxy.clientsmu.Lock()
+	defer proxy.clientsmu.Unlock()
+
+	c := &Client{conn: conn}
+	proxy.clients[c] = struct{}{}
+
+	return c
+}
+
+// Detach detaches a control panel from the proxy. Once the last control panel
+// is detached, stalled requests and responses are forwarded unmodified, since
+// no one is left to forward them.
+func (proxy *Proxy) Detach(c *Client) {
+	proxy.clientsmu.Lock()
+	defer proxy.clientsmu.Unlock()
+
+	delete(proxy.clients, c)
+
+	if len(proxy.clients) == 0 {
+		proxy.queue.release()
+	}
+}
+
+// broadcast sends a command to every attached control panel. It reports
+// whether the command was sent to at least one of them.
+func (proxy *Proxy) broadcast(msg *ProxyCmd) bool {
+	var (
+		payload []byte
+		sent    bool
+		err     error
+	)
+
+	if payload, err = json.Marshal(msg); err != nil {
+		return false
+	}
+
+	proxy.clientsmu.Lock()
+	defer proxy.clientsmu.Unlock()
+
+	for c := range proxy.clients {
+		if err = c.send(payload); err == nil {
+			sent = true
+		}
+	}
+
+	return sent
+}
@@ -1,7 +1,10 @@
 package proxy
 
 import (
+	"encoding/base64"
 	"errors"
+	"time"
+	"unicode/utf8"
 )
 
 var (
@@ -9,6 +12,11 @@ var (
 	ErrInvalidCommand  = errors.New("invalid command")
 	ErrNilBuffer       = errors.New("buffer is nil")
 	ErrDropped         = errors.New("data was dropped")
+	ErrUnknownItem     = errors.New("unknown intercepted item")
+	ErrDropAction      = errors.New("unknown drop action")
+	ErrDefaultDrop     = errors.New("the default drop action must be close or status")
+	ErrDropStatus      = errors.New("drop status must be between 200 and 599")
+	ErrInvalidEncoding = errors.New("unknown data encoding")
 )
 
 type ProxyCmdType byte
@@ -20,6 +28,10 @@ const (
 	ProxyCmdStall
 	ProxyCmdForward
 	ProxyCmdDrop
+	ProxyCmdEdit
+	ProxyCmdList
+	ProxyCmdQueue
+	ProxyCmdError
 )
 
 var proxyCmdTypes = map[ProxyCmdType]string{
@@ -29,32 +41,134 @@ var proxyCmdTypes = map[ProxyCmdType]string{
 	ProxyCmdStall:   "ProxyCmdStall",
 	ProxyCmdForward: "ProxyCmdForward",
 	ProxyCmdDrop:    "ProxyCmdDrop",
+	ProxyCmdEdit:    "ProxyCmdEdit",
+	ProxyCmdList:    "ProxyCmdList",
+	ProxyCmdQueue:   "ProxyCmdQueue",
+	ProxyCmdError:   "ProxyCmdError",
 }
 
 func (m ProxyCmdType) String() string {
 	return proxyCmdTypes[m]
 }
 
-// ProxyCmd is a command to be processed by a proxy listener.
+// Direction is the direction of an intercepted message.
+type Direction string
+
+const (
+	DirectionRequest  Direction = "request"  // Sent by the client to the server
+	DirectionResponse Direction = "response" // Sent by the server to the client
+)
+
+// Encodings of the data payloads of commands. Payloads that aren't valid UTF-8
+// are sent base64-encoded, so that binary messages survive the round trip
+// through the control panel byte for byte.
+const (
+	EncodingUTF8   = "utf8"
+	EncodingBase64 = "base64"
+)
+
+// EncodePayload encodes a data payload sent to the control panel, and returns it
+// with its encoding.
+func EncodePayload(data []byte) (string, string) {
+	if utf8.Valid(data) {
+		return string(data), EncodingUTF8
+	}
+
+	return base64.StdEncoding.EncodeToString(data), EncodingBase64
+}
+
+// DropAction is how the proxy answers the client when an item is dropped.
+type DropAction string
+
+const (
+	DropClose    DropAction = "close"    // Close the client connection
+	DropStatus   DropAction = "status"   // Send a status page generated by the proxy
+	DropResponse DropAction = "response" // Send the response carried by the command
+)
+
+// Valid reports whether the drop action is known.
+func (a DropAction) Valid() bool {
+	return a == DropClose || a == DropStatus || a == DropResponse
+}
+
+// ValidDefault reports whether the drop action can be taken for items dropped by
+// commands that don't say how. Canned responses are only carried by commands.
+func (a DropAction) ValidDefault() bool {
+	return a == DropClose || a == DropStatus
+}
+
+// ValidDropStatus reports whether a status code can be sent for a dropped item.
+func ValidDropStatus(status int) bool {
+	return status >= 200 && status <= 599
+}
+
+// ProxyCmd is a command to be processed by a proxy listener. Commands sent to the
+// control panel for stalled items carry the item's ID and metadata, and commands
+// sent back for them may use the ID to refer to a specific item.
 type ProxyCmd struct {
-	Type ProxyCmdType `json:"type"` // Command type
-	Data string       `json:"data"` // Command data payload
+	Type       ProxyCmdType `json:"type"`                 // Command type
+	ID         string       `json:"id,omitempty"`         // Unique ID of a stalled item
+	Direction  Direction    `json:"direction,omitempty"`  // Direction of a stalled item
+	Host       string       `json:"host,omitempty"`       // Target host of a stalled item
+	Opcode     string       `json:"opcode,omitempty"`     // Opcode of a stalled WebSocket frame, such as text or binary
+	Timestamp  *time.Time   `json:"timestamp,omitempty"`  // Time a stalled item was intercepted
+	Action     DropAction   `json:"action,omitempty"`     // Action taken for a dropped item
+	Status     int          `json:"status,omitempty"`     // Status of the page sent for a dropped item
+	Data       string       `json:"data"`                 // Command data payload
+	Encoding   string       `json:"encoding,omitempty"`   // Encoding of the data payload, utf8 if empty
+	FixFraming *bool        `json:"fixFraming,omitempty"` // Fix the framing of an edited item, as the project's settings say if nil
+	Items      []ProxyCmd   `json:"items,omitempty"`      // Stalled items listed by ProxyCmdQueue
 }
 
-// Validate validates the data payloads of a ProxyCmd.
+// Payload returns the decoded data payload of a command.
+func (cmd *ProxyCmd) Payload() ([]byte, error) {
+	switch cmd.Encoding {
+	case EncodingUTF8, "":
+		return []byte(cmd.Data), nil
+	case EncodingBase64:
+		return base64.StdEncoding.DecodeString(cmd.Data)
+	}
+
+	return nil, ErrInvalidEncoding
+}
+
+// Validate validates the data payloads of a ProxyCmd. Forward and drop commands
+// without an ID apply to the item that was stalled first. Drop commands without
+// an action or status use the project's settings, and only carry data when it is
+// the response to send to the client.
 func (cmd *ProxyCmd) Validate() error {
 	switch cmd.Type {
-	case ProxyCmdStart, ProxyCmdStop, ProxyCmdDrop:
-		if cmd.Data != "" {
+	case ProxyCmdStart, ProxyCmdStop, ProxyCmdList:
+		if cmd.Data != "" || cmd.ID != "" {
 			return ErrInvalidCommand
 		}
-	case ProxyCmdStall, ProxyCmdForward:
+	case ProxyCmdDrop:
+		if cmd.Action != "" && !cmd.Action.Valid() {
+			return ErrDropAction
+		}
+
+		if cmd.Status != 0 && !ValidDropStatus(cmd.Status) {
+			return ErrDropStatus
+		}
+
+		if (cmd.Data != "") != (cmd.Action == DropResponse) {
+			return ErrInvalidCommand
+		}
+	case ProxyCmdForward:
 		if cmd.Data == "" {
 			return ErrInvalidCommand
 		}
+	case ProxyCmdEdit:
+		if cmd.Data == "" || cmd.ID == "" {
+			return ErrInvalidCommand
+		}
 	default:
 		return ErrUnknownProxyCmd
 	}
 
+	if _, err := cmd.Payload(); err != nil {
+		return ErrInvalidEncoding
+	}
+
 	return nil
 }
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// maxDecodedSize limits the size of decoded bodies, so that a small compressed
+// body can't exhaust the proxy's memory.
+const maxDecodedSize = 64 << 20
+
+var ErrDecodedSize = errors.New("decoded body is too large")
+
+// decoder removes a content coding from a body.
+func decoder(coding string, r io.Reader) (io.Reader, error) {
+	switch coding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// Some servers send raw deflate data instead of the zlib format
+		// the coding is meant to use.
+		br := bufio.NewReader(r)
+		if header, err := br.Peek(2); err == nil && (uint(header[0])<<8|uint(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+
+		return flate.NewReader(br), nil
+	case "br":
+		return brotli.NewReader(r), nil
+	case "identity", "":
+		return r, nil
+	}
+
+	return nil, fmt.Errorf("unsupported content coding %q", coding)
+}
+
+// decodeBody returns the body of a response with its transfer coding and
+// content codings removed.
+func decodeBody(res *http.Response) ([]byte, error) {
+	var (
+		codings []string
+		r       io.Reader
+		body    []byte
+		err     error
+	)
+
+	// The transfer coding is removed by the response's body reader.
+	r = res.Body
+
+	for _, header := range res.Header.Values("Content-Encoding") {
+		codings = append(codings, strings.Split(header, ",")...)
+	}
+
+	// Codings are listed in the order they were applied.
+	for i := len(codings) - 1; i >= 0; i-- {
+		if r, err = decoder(strings.ToLower(strings.TrimSpace(codings[i])), r); err != nil {
+			return nil, err
+		}
+	}
+
+	if body, err = io.ReadAll(io.LimitReader(r, maxDecodedSize+1)); err != nil {
+		return nil, err
+	}
+
+	if len(body) > maxDecodedSize {
+		return nil, ErrDecodedSize
+	}
+
+	return body, nil
+}
+
+// mimetype returns the media type of a response body from its Content-Type
+// header, or by sniffing the decoded body if the header is missing or invalid.
+func mimetype(res *http.Response, body []byte) string {
+	if mediatype, _, err := mime.ParseMediaType(res.Header.Get("Content-Type")); err == nil {
+		return mediatype
+	}
+
+	if len(body) == 0 {
+		return ""
+	}
+
+	mediatype, _, _ := mime.ParseMediaType(http.DetectContentType(body))
+	return mediatype
+}
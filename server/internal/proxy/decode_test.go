@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/ihaxolotl/webproxy/internal/buffer"
+)
+
+// testEncode compresses data with a content coding.
+func testEncode(t *testing.T, coding string, data []byte) []byte {
+	var (
+		buf bytes.Buffer
+		w   io.WriteCloser
+		err error
+	)
+
+	switch coding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, err = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	return buf.Bytes()
+}
+
+func TestDecodeBody(t *testing.T) {
+	body := []byte(`{"message": "hello, world"}`)
+
+	tests := []struct {
+		header string
+		body   []byte
+	}{
+		{"Content-Encoding: gzip\r\n", testEncode(t, "gzip", body)},
+		{"Content-Encoding: deflate\r\n", testEncode(t, "deflate", body)},
+		{"Content-Encoding: deflate\r\n", testEncode(t, "raw-deflate", body)},
+		{"Content-Encoding: br\r\n", testEncode(t, "br", body)},
+		{"Content-Encoding: deflate, GZIP\r\n", testEncode(t, "gzip", testEncode(t, "deflate", body))},
+	}
+
+	for _, tc := range tests {
+		var raw bytes.Buffer
+
+		// Send every body chunked, so the transfer coding is removed as well.
+		fmt.Fprintf(&raw, "HTTP/1.1 200 OK\r\n%sTransfer-Encoding: chunked\r\n\r\n", tc.header)
+		fmt.Fprintf(&raw, "%x\r\n%s\r\n0\r\n\r\n", len(tc.body), tc.body)
+
+		res, err := readResponse(nil, buffer.NewBufferFrom(raw.Bytes(), raw.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := decodeBody(res)
+		if err != nil {
+			t.Fatalf("fatal: %q: %v\n", tc.header, err)
+		}
+
+		if !bytes.Equal(decoded, body) {
+			t.Fatalf("fatal: %q: %q expected, %q decoded.\n", tc.header, body, decoded)
+		}
+
+		if mediatype := mimetype(res, decoded); mediatype != "text/plain" {
+			t.Fatalf("fatal: %q: sniffed %q.\n", tc.header, mediatype)
+		}
+	}
+}
+
+func TestMimetype(t *testing.T) {
+	raw := []byte("HTTP/1.1 200 OK\r\nContent-Type: Application/JSON; charset=utf-8\r\nContent-Length: 2\r\n\r\n{}")
+
+	res, err := readResponse(nil, buffer.NewBufferFrom(raw, len(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mediatype := mimetype(res, []byte("{}")); mediatype != "application/json" {
+		t.Fatalf("fatal: application/json expected, %q returned.\n", mediatype)
+	}
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	// detectTimeout is how long a tunneled client is given to send its first
+	// bytes before its protocol is assumed to be one where the server speaks first.
+	detectTimeout = 2 * time.Second
+
+	// idleTimeout is how long a client that isn't aware of the proxy is given
+	// to send its first bytes.
+	idleTimeout = 30 * time.Second
+)
+
+// peek reads the first bytes a client sends on a connection, waiting at most
+// for the given timeout. The bytes are returned even if the read fails.
+func peek(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	buf := make([]byte, 4096)
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	n, err := conn.Read(buf)
+
+	return buf[:n], err
+}
+
+// isTLS reports whether data starts with a TLS handshake record.
+func isTLS(data []byte) bool {
+	return len(data) > 0 && data[0] == 0x16
+}
+
+// isHTTP reports whether data starts with an HTTP request method followed by a
+// space. Methods are tokens of uppercase letters, such as GET or PROPFIND.
+func isHTTP(data []byte) bool {
+	for i, c := range data {
+		switch {
+		case c == ' ':
+			return i >= 3
+		case c < 'A' || c > 'Z':
+			return false
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,25 @@
+package proxy
+
+import "testing"
+
+func TestDetectProtocol(t *testing.T) {
+	tests := []struct {
+		data string
+		tls  bool
+		http bool
+	}{
+		{"GET / HTTP/1.1\r\n", false, true},
+		{"PROPFIND /dav HTTP/1.1\r\n", false, true},
+		{"\x16\x03\x01\x00\xa5\x01\x00\x00\xa1", true, false},
+		{"SSH-2.0-OpenSSH_8.9\r\n", false, false},
+		{"GO ", false, false},
+		{"GET", false, false},
+		{"", false, false},
+	}
+
+	for _, test := range tests {
+		if isTLS([]byte(test.data)) != test.tls || isHTTP([]byte(test.data)) != test.http {
+			t.Fatalf("fatal: %q: tls %t and http %t expected.\n", test.data, test.tls, test.http)
+		}
+	}
+}
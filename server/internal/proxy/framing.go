@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http/httputil"
+	"strconv"
+)
+
+// fixFraming makes the framing of an edited message match its body, so that the
+// receiver reads exactly the body that was sent. Bare LF line endings in the
+// header section are turned into CRLF. A chunked body that is no longer valid
+// chunked data is sent again as a single chunk, made of the data of its chunks
+// if their size lines are merely stale, or of the whole body. Otherwise, the Content-Length
+// header is set to the length of the body, and added to requests with a body
+// that have none. Responses that never have a body, such as responses to HEAD
+// requests, keep their framing headers.
+func fixFraming(raw []byte, head bool) []byte {
+	var (
+		start, headers, sep, body = splitMessage(raw)
+		lines                     [][]byte
+		chunked                   bool
+		length                    = -1
+		response                  = bytes.HasPrefix(start, []byte("HTTP/"))
+		dst                       []byte
+	)
+
+	for _, line := range bytes.SplitAfter(headers, []byte("\n")) {
+		if line = bytes.TrimRight(line, "\r\n"); len(line) == 0 {
+			continue
+		}
+
+		name, value := headerField(line)
+
+		switch {
+		case bytes.EqualFold(name, []byte("Transfer-Encoding")):
+			codings := bytes.Split(value, []byte(","))
+			chunked = bytes.EqualFold(bytes.TrimSpace(codings[len(codings)-1]), []byte("chunked"))
+		case bytes.EqualFold(name, []byte("Content-Length")):
+			// Only the position of the first Content-Length header is kept.
+			if length >= 0 {
+				continue
+			}
+
+			length = len(lines)
+		}
+
+		lines = append(lines, line)
+	}
+
+	switch {
+	case response && (head || bodyless(start)):
+	case chunked:
+		if !validChunked(body) {
+			body = rechunk(body)
+		}
+
+		// A Content-Length next to a chunked body is ambiguous, so it is removed.
+		if length >= 0 {
+			lines = append(lines[:length], lines[length+1:]...)
+		}
+	case length >= 0:
+		lines[length] = []byte("Content-Length: " + strconv.Itoa(len(body)))
+	case !response && len(body) > 0:
+		lines = append(lines, []byte("Content-Length: "+strconv.Itoa(len(body))))
+	}
+
+	dst = make([]byte, 0, len(raw)+len(lines)+32)
+	dst = append(append(dst, bytes.TrimRight(start, "\r\n")...), "\r\n"...)
+
+	for _, line := range lines {
+		dst = append(append(dst, line...), "\r\n"...)
+	}
+
+	// A header section that wasn't ended is ended before the body.
+	if len(sep) > 0 || len(body) == 0 {
+		dst = append(dst, "\r\n"...)
+	}
+
+	return append(dst, body...)
+}
+
+// headerField splits a header field line into its name and its value.
+func headerField(line []byte) (name, value []byte) {
+	i := bytes.IndexByte(line, ':')
+	if i < 0 {
+		return line, nil
+	}
+
+	return bytes.TrimSpace(line[:i]), bytes.TrimSpace(line[i+1:])
+}
+
+// bodyless reports whether the status in the start line of a response is one
+// of the statuses that are never followed by a body.
+func bodyless(start []byte) bool {
+	fields := bytes.Fields(start)
+	if len(fields) < 2 {
+		return false
+	}
+
+	status, err := strconv.Atoi(string(fields[1]))
+	if err != nil {
+		return false
+	}
+
+	return status/100 == 1 || status == 204 || status == 304
+}
+
+// validChunked reports whether a body is made of well-formed chunks, followed by
+// a trailer section that is ended by an empty line.
+func validChunked(body []byte) bool {
+	r := bufio.NewReader(bytes.NewReader(body))
+
+	if _, err := io.Copy(io.Discard, httputil.NewChunkedReader(r)); err != nil {
+		return false
+	}
+
+	rest, _ := io.ReadAll(r)
+
+	return bytes.Equal(rest, []byte("\r\n")) || bytes.HasSuffix(rest, []byte("\r\n\r\n"))
+}
+
+// rechunk encodes the data of a chunked body with stale chunk sizes as a single
+// chunk followed by the last chunk and the original trailer section. The data of
+// each chunk is taken to be the line following its size line. A body that isn't
+// made of such lines is encoded as a whole.
+func rechunk(body []byte) []byte {
+	var (
+		data    []byte
+		trailer = []byte("\r\n")
+		dst     []byte
+	)
+
+	for off := 0; ; {
+		i := bytes.Index(body[off:], []byte("\r\n"))
+		if i < 0 {
+			data = body
+			break
+		}
+
+		// Chunk extensions after the size are ignored.
+		line := body[off : off+i]
+		if j := bytes.IndexByte(line, ';'); j >= 0 {
+			line = line[:j]
+		}
+
+		size, err := strconv.ParseUint(string(bytes.TrimSpace(line)), 16, 64)
+		if err != nil {
+			data = body
+			break
+		}
+
+		off += i + 2
+
+		if size == 0 {
+			if rest := body[off:]; bytes.Equal(rest, []byte("\r\n")) || bytes.HasSuffix(rest, []byte("\r\n\r\n")) {
+				trailer = rest
+			}
+
+			break
+		}
+
+		if i = bytes.Index(body[off:], []byte("\r\n")); i < 0 {
+			data = body
+			break
+		}
+
+		data = append(data, body[off:off+i]...)
+		off += i + 2
+	}
+
+	if len(data) > 0 {
+		dst = append(append([]byte(fmt.Sprintf("%x\r\n", len(data))), data...), "\r\n"...)
+	}
+
+	return append(append(dst, "0\r\n"...), trailer...)
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import "testing"
+
+func TestFixFraming(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		head     bool
+		expected string
+	}{
+		{
+			name:     "content-length",
+			raw:      "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhello",
+			expected: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello",
+		},
+		{
+			name:     "bare lf",
+			raw:      "POST / HTTP/1.1\nHost: localhost\ncontent-length: 5\n\nhi\n",
+			expected: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nhi\n",
+		},
+		{
+			name:     "missing length",
+			raw:      "POST / HTTP/1.1\r\nHost: localhost\r\n\r\nhello",
+			expected: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello",
+		},
+		{
+			name:     "duplicate length",
+			raw:      "POST / HTTP/1.1\r\nContent-Length: 1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhello",
+			expected: "POST / HTTP/1.1\r\nContent-Length: 5\r\nHost: localhost\r\n\r\nhello",
+		},
+		{
+			name:     "unterminated headers",
+			raw:      "GET / HTTP/1.1\nHost: localhost",
+			expected: "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		},
+		{
+			name:     "valid chunks",
+			raw:      "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+			expected: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+		},
+		{
+			name:     "rechunk",
+			raw:      "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\n5\r\nhello world\r\n0\r\n\r\n",
+			expected: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nb\r\nhello world\r\n0\r\n\r\n",
+		},
+		{
+			name:     "rechunk trailer",
+			raw:      "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n1;ext\r\nhello\r\n1\r\n world\r\n0\r\nX-Sum: 1\r\n\r\n",
+			expected: "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nb\r\nhello world\r\n0\r\nX-Sum: 1\r\n\r\n",
+		},
+		{
+			name:     "rechunk raw body",
+			raw:      "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nhello",
+			expected: "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+		},
+		{
+			name:     "close delimited",
+			raw:      "HTTP/1.0 200 OK\r\n\r\nhello",
+			expected: "HTTP/1.0 200 OK\r\n\r\nhello",
+		},
+		{
+			name:     "head",
+			raw:      "HTTP/1.1 200 OK\nContent-Length: 5\n\n",
+			head:     true,
+			expected: "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n",
+		},
+		{
+			name:     "not modified",
+			raw:      "HTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\n",
+			expected: "HTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\n",
+		},
+	}
+
+	for _, test := range tests {
+		if dst := fixFraming([]byte(test.raw), test.head); string(dst) != test.expected {
+			t.Fatalf("fatal: %s: %q expected, %q returned.\n", test.name, test.expected, dst)
+		}
+	}
+}
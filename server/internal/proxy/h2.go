@@ -0,0 +1,372 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ihaxolotl/webproxy/internal/buffer"
+	"github.com/ihaxolotl/webproxy/internal/data/replace"
+	"golang.org/x/net/http2"
+)
+
+// Protocol versions requests are recorded with.
+const (
+	protoHTTP11 = "HTTP/1.1"
+	protoHTTP2  = "HTTP/2.0"
+)
+
+// nextProtos are the protocols offered with ALPN, in order of preference.
+var nextProtos = []string{http2.NextProtoTLS, "http/1.1"}
+
+// h2Transport opens HTTP/2 connections to target servers. Responses are left
+// compressed, as they are over HTTP/1.
+var h2Transport = &http2.Transport{DisableCompression: true}
+
+// hopByHop are the header fields that only apply to a single HTTP/1 connection,
+// and may not be sent over HTTP/2.
+var hopByHop = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// stripHopByHop removes the header fields that may not be sent over HTTP/2. The
+// TE header is only kept when it asks for trailers.
+func stripHopByHop(header http.Header) {
+	for _, name := range hopByHop {
+		header.Del(name)
+	}
+
+	if te := header.Get("Te"); te != "" && te != "trailers" {
+		header.Del("Te")
+	}
+}
+
+// serveH2 handles the streams of a client connection that negotiated HTTP/2,
+// until either side closes it. The requests received on the streams are
+// exchanged like those read from HTTP/1 connections, and share the connections
+// to target servers.
+func (proxy *Proxy) serveH2(conn net.Conn, tunnel *tunnel) error {
+	var (
+		server = &http2.Server{}
+		pool   = &streamUpstreams{h2: make(upstreams), idle: make(map[string][]*upstream)}
+	)
+
+	defer pool.close()
+
+	server.ServeConn(conn, &http2.ServeConnOpts{
+		Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			proxy.handleStream(conn, rw, r, tunnel, pool)
+		}),
+	})
+
+	return nil
+}
+
+// streamUpstreams holds the connections to target servers opened on behalf of
+// the streams of an HTTP/2 client connection. HTTP/2 connections are shared by
+// the streams, which are multiplexed over them, while HTTP/1 connections are
+// lent to one stream at a time, so that a stream stalled at the control panel
+// doesn't hold up the others.
+type streamUpstreams struct {
+	mu     sync.Mutex
+	h2     upstreams              // HTTP/2 connections, keyed like upstreams
+	idle   map[string][]*upstream // HTTP/1 connections that aren't lent to a stream
+	closed bool                   // Whether the client connection was closed
+}
+
+// lend returns the connections a stream exchanges its request over, with the
+// connection to the request's target server if there is one that can be used.
+func (p *streamUpstreams) lend(req *http.Request) upstreams {
+	var (
+		key = upstreamKey(req)
+		ups = make(upstreams)
+	)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if up, ok := p.h2[key]; ok {
+		if up.h2.CanTakeNewRequest() {
+			ups[key] = up
+			return ups
+		}
+
+		up.conn.Close()
+		delete(p.h2, key)
+	}
+
+	if idle := p.idle[key]; len(idle) > 0 {
+		ups[key] = idle[len(idle)-1]
+		p.idle[key] = idle[:len(idle)-1]
+	}
+
+	return ups
+}
+
+// release takes back the connections a stream exchanged its request over, once
+// the exchange is over. Connections that were discarded aren't among them.
+func (p *streamUpstreams) release(ups upstreams) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, up := range ups {
+		delete(ups, key)
+
+		switch {
+		case p.closed:
+			up.conn.Close()
+		case up.h2 == nil:
+			p.idle[key] = append(p.idle[key], up)
+		case p.h2[key] == up:
+		case p.h2[key] != nil && p.h2[key].h2.CanTakeNewRequest():
+			// Streams that dialed the target server at the same time keep the
+			// connection that was released first.
+			up.conn.Close()
+		default:
+			p.h2[key] = up
+		}
+	}
+}
+
+// close closes every connection to target servers, including those released by
+// streams that are still being handled.
+func (p *streamUpstreams) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	p.h2.close()
+
+	for key, idle := range p.idle {
+		for _, up := range idle {
+			up.conn.Close()
+		}
+
+		delete(p.idle, key)
+	}
+}
+
+// handleStream handles a request received on an HTTP/2 stream. The request is
+// rendered in HTTP/1 form, which is what is rewritten, stalled and recorded, and
+// converted back to HTTP/2 when it is sent on. The stream is reset if it isn't
+// answered, as when a dropped request is answered by closing the connection.
+func (proxy *Proxy) handleStream(
+	conn net.Conn,
+	rw http.ResponseWriter,
+	r *http.Request,
+	tunnel *tunnel,
+	pool *streamUpstreams,
+) {
+	var (
+		s             = &stream{Conn: conn, rw: rw, req: r}
+		ups           upstreams
+		clientRequest *buffer.Buffer
+		httpRequest   *http.Request
+		err           error
+	)
+
+	if clientRequest, err = renderRequest(r); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+
+	if httpRequest, err = readRequest(clientRequest); err == nil {
+		err = tunnel.route(httpRequest)
+	}
+
+	if err != nil {
+		err = proxy.handleMalformedRequest(s, clientRequest, tunnel, err)
+	} else {
+		if tunnel.hostHeader != "" {
+			clientRequest = rewriteHost(clientRequest, tunnel.hostHeader)
+		}
+
+		ups = pool.lend(httpRequest)
+		_, err = proxy.handleExchange(s, nil, ups, clientRequest, httpRequest)
+		pool.release(ups)
+	}
+
+	if err != nil {
+		log.Println(err)
+	}
+
+	if !s.written {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// stream is the client connection as seen by an exchange on an HTTP/2 stream.
+// Responses are written to it whole, in HTTP/1 form, and are sent on the stream
+// as HTTP/2 frames.
+type stream struct {
+	net.Conn
+	rw      http.ResponseWriter // Writer of the stream's response
+	req     *http.Request       // Request received on the stream
+	written bool                // Whether the stream was answered
+}
+
+// answering sets the request that a response written to a client connection
+// answers, when it was edited after it was received. Responses written to HTTP/2
+// streams are parsed as responses to it.
+func answering(conn net.Conn, req *http.Request) {
+	if s, ok := conn.(*stream); ok {
+		s.req = req
+	}
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(p)), s.req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	header := s.rw.Header()
+	for name, values := range res.Header {
+		header[name] = values
+	}
+	stripHopByHop(header)
+
+	s.rw.WriteHeader(res.StatusCode)
+	s.written = true
+
+	if _, err = io.Copy(s.rw, res.Body); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// renderRequest renders a request received on an HTTP/2 stream in HTTP/1 form,
+// with its version kept as HTTP/2.0. The pseudo-header fields are rendered as the
+// start line and the Host header, and the body is framed with a Content-Length.
+func renderRequest(r *http.Request) (*buffer.Buffer, error) {
+	var (
+		b      bytes.Buffer
+		header http.Header
+		body   []byte
+		err    error
+	)
+
+	if body, err = io.ReadAll(r.Body); err != nil {
+		return nil, err
+	}
+
+	header = r.Header.Clone()
+	header.Del("Host")
+	header.Del("Content-Length")
+
+	if len(body) > 0 {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	fmt.Fprintf(&b, "%s %s %s\r\nHost: %s\r\n", r.Method, r.RequestURI, protoHTTP2, r.Host)
+	header.Write(&b)
+	b.WriteString("\r\n")
+	b.Write(body)
+
+	return buffer.NewBufferFrom(b.Bytes(), b.Len()), nil
+}
+
+// renderResponse renders a response received over HTTP/2 in HTTP/1 form, with its
+// version kept as HTTP/2.0. The body is framed with a Content-Length.
+func renderResponse(res *http.Response, body []byte, method string) *buffer.Buffer {
+	var (
+		b      bytes.Buffer
+		header = res.Header.Clone()
+		status = res.StatusCode
+	)
+
+	if method != http.MethodHead && status/100 != 1 && status != 204 && status != 304 {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	fmt.Fprintf(&b, "%s %d %s\r\n", protoHTTP2, status, http.StatusText(status))
+	header.Write(&b)
+	b.WriteString("\r\n")
+	b.Write(body)
+
+	return buffer.NewBufferFrom(b.Bytes(), b.Len())
+}
+
+// h2Request converts a request in HTTP/1 form back to a request that can be sent
+// over HTTP/2 to the target of the exchange.
+func h2Request(proxyRequest *buffer.Buffer, httpRequest *http.Request) (*http.Request, error) {
+	req, err := reparseRequest(proxyRequest, httpRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	req.RequestURI = ""
+	stripHopByHop(req.Header)
+
+	return req, nil
+}
+
+// downgrade rewrites the version of a request rendered from an HTTP/2 stream to
+// HTTP/1.1, for target servers that don't speak HTTP/2.
+func downgrade(src *buffer.Buffer) *buffer.Buffer {
+	filters := []filter{
+		{
+			location: replace.LocationStartLine,
+			pattern:  regexp.MustCompile(`(?i) HTTP/2(\.0)?(\r?\n)$`),
+			replace:  []byte(" " + protoHTTP11 + "${2}"),
+		},
+	}
+
+	dst, _ := filterMessage(src.Buffer(), filters, false)
+
+	return buffer.NewBufferFrom(dst, len(dst))
+}
+
+// roundTripH2 sends a request to its target server over an HTTP/2 connection and
+// reads the response, which is returned in HTTP/1 form. The time the request was
+// sent and the time until the response headers arrived are recorded in the
+// exchange data.
+func roundTripH2(
+	up *upstream,
+	proxyRequest *buffer.Buffer,
+	httpRequest *http.Request,
+	d *httpdata,
+) (*buffer.Buffer, error) {
+	var (
+		req  *http.Request
+		res  *http.Response
+		body []byte
+		err  error
+	)
+
+	if req, err = h2Request(proxyRequest, httpRequest); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), responseTimeout)
+	defer cancel()
+
+	d.RequestTime = time.Now()
+
+	if res, err = up.h2.RoundTrip(req.WithContext(ctx)); err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	d.Timing.TTFB = time.Since(d.RequestTime)
+
+	if body, err = io.ReadAll(res.Body); err != nil {
+		return nil, err
+	}
+
+	return renderResponse(res, body, httpRequest.Method), nil
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ihaxolotl/webproxy/internal/buffer"
+)
+
+func TestRenderRequest(t *testing.T) {
+	expected := "POST /login?next=%2F HTTP/2.0\r\nHost: example.com\r\nContent-Length: 5\r\nCookie: a=b\r\n\r\nhello"
+
+	req, err := http.NewRequest(http.MethodPost, "https://example.com/login?next=%2F", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RequestURI = "/login?next=%2F"
+	req.Header.Set("Cookie", "a=b")
+
+	buf, err := renderRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf.Buffer()) != expected {
+		t.Fatalf("fatal: %q expected, %q returned.\n", expected, buf.Buffer())
+	}
+
+	if _, err = readRequest(buf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenderResponse(t *testing.T) {
+	tests := []struct {
+		method   string
+		status   int
+		body     string
+		expected string
+	}{
+		{http.MethodGet, http.StatusOK, "hello", "HTTP/2.0 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\nhello"},
+		{http.MethodHead, http.StatusOK, "", "HTTP/2.0 200 OK\r\nContent-Type: text/plain\r\n\r\n"},
+		{http.MethodGet, http.StatusNoContent, "", "HTTP/2.0 204 No Content\r\nContent-Type: text/plain\r\n\r\n"},
+	}
+
+	for _, test := range tests {
+		res := &http.Response{
+			StatusCode: test.status,
+			Header:     http.Header{"Content-Type": {"text/plain"}},
+		}
+
+		buf := renderResponse(res, []byte(test.body), test.method)
+		if string(buf.Buffer()) != test.expected {
+			t.Fatalf("fatal: %q expected, %q returned.\n", test.expected, buf.Buffer())
+		}
+	}
+}
+
+func TestH2Request(t *testing.T) {
+	raw := []byte("POST /upload HTTP/2.0\r\nHost: example.com\r\nConnection: keep-alive\r\nTransfer-Encoding: chunked\r\n" +
+		"TE: trailers\r\n\r\n5\r\nhello\r\n0\r\n\r\n")
+
+	target, err := http.NewRequest(http.MethodPost, "https://example.com:8443/upload", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := h2Request(buffer.NewBufferFrom(raw, len(raw)), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.URL.String() != "https://example.com:8443/upload" || req.Host != "example.com" || req.RequestURI != "" {
+		t.Fatalf("fatal: request to %q with host %q was not routed to its target.\n", req.URL, req.Host)
+	}
+
+	for _, name := range []string{"Connection", "Transfer-Encoding"} {
+		if req.Header.Get(name) != "" {
+			t.Fatalf("fatal: %s header was not removed.\n", name)
+		}
+	}
+
+	if req.Header.Get("Te") != "trailers" {
+		t.Fatalf("fatal: TE: trailers header was removed.\n")
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "hello" {
+		t.Fatalf("fatal: %q expected, %q returned.\n", "hello", body)
+	}
+}
+
+func TestDowngrade(t *testing.T) {
+	raw := []byte("GET /HTTP/2.0 HTTP/2.0\r\nHost: example.com\r\n\r\n")
+	expected := "GET /HTTP/2.0 HTTP/1.1\r\nHost: example.com\r\n\r\n"
+
+	buf := downgrade(buffer.NewBufferFrom(raw, len(raw)))
+
+	if string(buf.Buffer()) != expected {
+		t.Fatalf("fatal: %q expected, %q returned.\n", expected, buf.Buffer())
+	}
+}
+
+func TestStreamUpstreams(t *testing.T) {
+	h2 := httptest.NewUnstartedServer(http.NotFoundHandler())
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+
+	h1 := httptest.NewServer(http.NotFoundHandler())
+	defer h1.Close()
+
+	pool := &streamUpstreams{h2: make(upstreams), idle: make(map[string][]*upstream)}
+
+	for _, target := range []string{h2.URL, h1.URL} {
+		u, err := url.Parse(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := &http.Request{URL: u, ProtoMajor: 2}
+		key := upstreamKey(req)
+
+		ups := pool.lend(req)
+		if len(ups) != 0 {
+			t.Fatalf("fatal: %s: connection lent before one was dialed.\n", target)
+		}
+
+		if ups[key], err = dial(req, nil); err != nil {
+			t.Fatal(err)
+		}
+		up := ups[key]
+
+		pool.release(ups)
+
+		// HTTP/2 connections are shared by streams, and HTTP/1 connections
+		// are lent to one stream at a time.
+		first, second := pool.lend(req), pool.lend(req)
+		if first[key] != up || (second[key] == up) != (up.h2 != nil) {
+			t.Fatalf("fatal: %s: connection was not lent as expected.\n", target)
+		}
+
+		pool.release(first)
+		pool.release(second)
+	}
+
+	pool.close()
+
+	if len(pool.h2) != 0 || len(pool.idle) != 0 {
+		t.Fatalf("fatal: connections were kept after the pool was closed.\n")
+	}
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ihaxolotl/webproxy/internal/data"
+)
+
+var (
+	ErrProxyRunning    = errors.New("proxy is already running")
+	ErrProxyNotRunning = errors.New("proxy is not running")
+)
+
+// Manager keeps track of the proxies running for each project, so that they
+// outlive the control panel connections attached to them.
+type Manager struct {
+	mu      sync.Mutex
+	db      *data.Database
+	proxies map[string]*Proxy
+}
+
+// NewManager allocates memory for and returns a Manager.
+func NewManager(db *data.Database) *Manager {
+	return &Manager{
+		db:      db,
+		proxies: make(map[string]*Proxy),
+	}
+}
+
+// Start spawns a proxy for a project.
+func (m *Manager) Start(projectId string) (*Proxy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.start(projectId)
+}
+
+func (m *Manager) start(projectId string) (*Proxy, error) {
+	var (
+		proxy *Proxy
+		err   error
+	)
+
+	if _, ok := m.proxies[projectId]; ok {
+		return nil, ErrProxyRunning
+	}
+
+	proxy = New(projectId, m.db)
+	if err = proxy.Spawn(); err != nil {
+		return nil, err
+	}
+
+	m.proxies[projectId] = proxy
+
+	return proxy, nil
+}
+
+// Stop closes the proxy running for a project.
+func (m *Manager) Stop(projectId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.stop(projectId)
+}
+
+func (m *Manager) stop(projectId string) error {
+	proxy, ok := m.proxies[projectId]
+	if !ok {
+		return ErrProxyNotRunning
+	}
+
+	delete(m.proxies, projectId)
+
+	return proxy.Close()
+}
+
+// Restart closes the proxy running for a project, if any, and spawns a new one.
+// Control panels attached to the previous proxy are not carried over.
+func (m *Manager) Restart(projectId string) (*Proxy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.stop(projectId); err != nil && err != ErrProxyNotRunning {
+		return nil, err
+	}
+
+	return m.start(projectId)
+}
+
+// Get returns the proxy running for a project.
+func (m *Manager) Get(projectId string) (*Proxy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	proxy, ok := m.proxies[projectId]
+	if !ok {
+		return nil, ErrProxyNotRunning
+	}
+
+	return proxy, nil
+}
+
+// Status returns the state of the proxy for a project.
+func (m *Manager) Status(projectId string) Status {
+	proxy, err := m.Get(projectId)
+	if err != nil {
+		return Status{Running: false}
+	}
+
+	return proxy.Status()
+}
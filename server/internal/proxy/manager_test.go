@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/ihaxolotl/webproxy/internal/data/settings"
+)
+
+// testManager returns a manager for a test project, whose proxy listens on a
+// port picked by the system.
+func testManager(t *testing.T) (*Manager, string) {
+	db, projectId := testDatabase(t)
+
+	s := settings.Default(projectId)
+	s.Listeners = []settings.Listener{
+		{BindAddress: "127.0.0.1", Port: 0, Mode: settings.DefaultMode},
+	}
+	if err := db.Settings.Save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewManager(db), projectId
+}
+
+// testDial reports whether a proxy listener accepts connections.
+func testDial(addr string) bool {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+
+	return true
+}
+
+func TestManagerLifecycle(t *testing.T) {
+	m, projectId := testManager(t)
+
+	if status := m.Status(projectId); status.Running {
+		t.Fatalf("fatal: proxy running before it was started: %+v\n", status)
+	}
+
+	if err := m.Stop(projectId); err != ErrProxyNotRunning {
+		t.Fatalf("fatal: %v expected, %v returned.\n", ErrProxyNotRunning, err)
+	}
+
+	prox, err := m.Start(projectId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = m.Start(projectId); err != ErrProxyRunning {
+		t.Fatalf("fatal: %v expected, %v returned.\n", ErrProxyRunning, err)
+	}
+
+	if running, err := m.Get(projectId); err != nil || running != prox {
+		t.Fatalf("fatal: running proxy expected, %v returned.\n", err)
+	}
+
+	status := m.Status(projectId)
+	if !status.Running || len(status.Addresses) != 1 || !testDial(status.Addresses[0]) {
+		t.Fatalf("fatal: proxy not listening: %+v\n", status)
+	}
+	addr := status.Addresses[0]
+
+	// Restarting replaces the running proxy with a new one.
+	restarted, err := m.Restart(projectId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restarted == prox {
+		t.Fatal("fatal: proxy was not replaced by a restart.")
+	}
+
+	if status = m.Status(projectId); !status.Running || !testDial(status.Addresses[0]) {
+		t.Fatalf("fatal: restarted proxy not listening: %+v\n", status)
+	}
+	addrs := []string{addr, status.Addresses[0]}
+
+	if err = m.Stop(projectId); err != nil {
+		t.Fatal(err)
+	}
+
+	if status = m.Status(projectId); status.Running {
+		t.Fatalf("fatal: proxy running after it was stopped: %+v\n", status)
+	}
+
+	if _, err = m.Get(projectId); err != ErrProxyNotRunning {
+		t.Fatalf("fatal: %v expected, %v returned.\n", ErrProxyNotRunning, err)
+	}
+
+	for _, addr = range addrs {
+		if testDial(addr) {
+			t.Fatalf("fatal: stopped proxy still accepts connections on %s.\n", addr)
+		}
+	}
+
+	if err = m.Stop(projectId); err != ErrProxyNotRunning {
+		t.Fatalf("fatal: %v expected, %v returned.\n", ErrProxyNotRunning, err)
+	}
+
+	// Restarting a proxy that isn't running starts it.
+	if _, err = m.Restart(projectId); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = m.Stop(projectId); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManagerStopReleasesStalled(t *testing.T) {
+	m, projectId := testManager(t)
+
+	prox, err := m.Start(projectId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := prox.queue.push(testQueueData("GET / HTTP/1.1\r\n\r\n"), DirectionRequest, "localhost", "")
+
+	if status := m.Status(projectId); status.Pending != 1 {
+		t.Fatalf("fatal: 1 stalled item expected, %d pending.\n", status.Pending)
+	}
+
+	if err = m.Stop(projectId); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case cmd := <-item.reply:
+		if cmd.Type != ProxyCmdForward {
+			t.Fatalf("fatal: %s expected, %s received.\n", ProxyCmdForward, cmd.Type)
+		}
+	default:
+		t.Fatal("fatal: stalled item was not released.")
+	}
+
+	if n := prox.queue.len(); n != 0 {
+		t.Fatalf("fatal: no stalled items expected, %d pending.\n", n)
+	}
+}
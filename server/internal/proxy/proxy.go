@@ -2,159 +2,628 @@ package proxy
 
 import (
 	"bytes"
-	"encoding/json"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 	"github.com/ihaxolotl/webproxy/internal/buffer"
+	"github.com/ihaxolotl/webproxy/internal/certs"
 	"github.com/ihaxolotl/webproxy/internal/data"
 	"github.com/ihaxolotl/webproxy/internal/data/requests"
 	"github.com/ihaxolotl/webproxy/internal/data/responses"
+	"github.com/ihaxolotl/webproxy/internal/data/settings"
+	"golang.org/x/net/http2"
 )
 
-var ErrTmpNoOptions = errors.New("OPTIONS requests not allowed (yet)")
+// connectEstablished is sent to the client once a CONNECT tunnel is accepted.
+const connectEstablished = "HTTP/1.1 200 Connection Established\r\n\r\n"
 
-// DefaultPort for testing. The user should soon be able to set whichever
-// port they want to listen on in their project settings.
-const DefaultPort = 8080
+// ListenerMode is the protocol clients speak to a proxy listener.
+type ListenerMode string
+
+const (
+	ModeHTTP      ListenerMode = "http"      // HTTP proxy requests and CONNECT tunnels
+	ModeSOCKS5    ListenerMode = "socks5"    // SOCKS5 tunnels
+	ModeInvisible ListenerMode = "invisible" // Requests from clients unaware of the proxy
+	ModeReverse   ListenerMode = "reverse"   // Requests forwarded to a fixed target
+)
+
+var (
+	ErrListenerMode = errors.New("listener mode must be http, socks5, invisible or reverse")
+	ErrNoHost       = errors.New("the request has no Host header")
+	ErrTarget       = errors.New("the target must be an http or https origin, such as https://host:port")
+)
+
+// Valid reports whether the listener mode is known.
+func (m ListenerMode) Valid() bool {
+	return m == ModeHTTP || m == ModeSOCKS5 || m == ModeInvisible || m == ModeReverse
+}
+
+// ParseTarget parses the origin that a reverse listener forwards requests to.
+// The origin is made of a scheme, http or https, a host and an optional port.
+func ParseTarget(target string) (*url.URL, error) {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" ||
+		u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return nil, ErrTarget
+	}
+
+	return &url.URL{Scheme: u.Scheme, Host: u.Host}, nil
+}
+
+// Listener is the address a proxy listener is bound to.
+type Listener struct {
+	BindAddress string       // Address of the interface to listen on
+	Port        int          // Port to listen on
+	Mode        ListenerMode // Protocol clients speak to the listener
+	Target      *url.URL     // Origin requests are forwarded to by reverse listeners
+	HostHeader  string       // Host header sent to the target by reverse listeners, if rewritten
+}
+
+// Address returns the host:port address of the listener.
+func (l Listener) Address() string {
+	return net.JoinHostPort(l.BindAddress, strconv.Itoa(l.Port))
+}
 
 // Options represents the configuration object for the proxy.
 type Options struct {
-	ListenPort      int  // Proxy listener port
-	InterceptClient bool // Intercept client HTTP requests
-	InterceptServer bool // Intercept server HTTP responses
-	Stall           bool // Stall enables stalling requests/responses
+	Listeners       []Listener // Proxy listeners
+	InterceptClient bool       // Intercept client HTTP requests
+	InterceptServer bool       // Intercept server HTTP responses
+	Stall           bool       // Stall enables stalling requests/responses
+	PassOutOfScope  bool       // Pass out-of-scope traffic without logging or interception
+	DropAction      DropAction // Default action taken when an item is dropped
+	DropStatus      int        // Default status of the page sent for dropped items
+	FixFraming      bool       // Fix the line endings and framing of edited items
+}
+
+// optionsFrom creates the proxy configuration from the settings of a project.
+func optionsFrom(s *settings.Settings) Options {
+	opts := Options{
+		InterceptClient: s.InterceptClient,
+		InterceptServer: s.InterceptServer,
+		Stall:           s.Stall,
+		PassOutOfScope:  s.PassOutOfScope,
+		DropAction:      DropAction(s.DropAction),
+		DropStatus:      s.DropStatus,
+		FixFraming:      s.FixFraming,
+	}
+
+	for _, l := range s.Listeners {
+		mode := ListenerMode(l.Mode)
+		if mode == "" {
+			mode = ModeHTTP
+		}
+
+		// Targets are validated when the settings are saved.
+		target, _ := ParseTarget(l.Target)
+
+		opts.Listeners = append(opts.Listeners, Listener{
+			BindAddress: l.BindAddress,
+			Port:        l.Port,
+			Mode:        mode,
+			Target:      target,
+			HostHeader:  l.HostHeader,
+		})
+	}
+
+	return opts
 }
 
 // Proxy is an intercepting proxy server.
 type Proxy struct {
-	projectId string          // Unique ID of the project. NOTE: This may be moved.
-	db        *data.Database  // Database connection
-	conn      *websocket.Conn // Client WebSocket connection
-	cmd       chan ProxyCmd   // Command queue channel
-	intcmd    chan ProxyCmd   // Intercept behaviour command queue channel
+	projectId string                // Unique ID of the project. NOTE: This may be moved.
+	db        *data.Database        // Database connection
+	clients   map[*Client]struct{}  // Control panel WebSocket connections
+	clientsmu sync.Mutex            // Guards clients
+	queue     queue                 // Interceptions waiting for a command
+	opts      Options               // Proxy configuration
+	optsmu    sync.RWMutex          // Guards opts, rules and certs, which are changed while running
+	rules     *ruleset              // Rules deciding how messages are scoped, rewritten and stalled
+	certs     *certs.Cache          // Leaf certificates for intercepted TLS connections
+	listeners []net.Listener        // Proxy listeners
+	conns     map[net.Conn]struct{} // Open client connections
+	connsmu   sync.Mutex            // Guards conns
+	started   time.Time             // Time the listeners were started
+	done      sync.WaitGroup        // Done once the listeners have stopped
+}
+
+// Status describes the state of a proxy.
+type Status struct {
+	Running     bool      `json:"running"`     // Whether the proxy is listening
+	Addresses   []string  `json:"addresses"`   // Addresses of the proxy listeners
+	Started     time.Time `json:"started"`     // Time the proxy was started
+	Stall       bool      `json:"stall"`       // Whether interception is enabled
+	Clients     int       `json:"clients"`     // Number of attached control panels
+	Connections int       `json:"connections"` // Number of open client connections
+	Pending     int       `json:"pending"`     // Number of stalled requests and responses
 }
 
 // New allocates memory for and returns a Proxy.
-func New(
-	projectId string,
-	db *data.Database,
-	conn *websocket.Conn,
-	cmd chan ProxyCmd,
-) *Proxy {
+func New(projectId string, db *data.Database) *Proxy {
 	return &Proxy{
 		projectId: projectId,
 		db:        db,
-		conn:      conn,
-		cmd:       cmd,
-		intcmd:    make(chan ProxyCmd),
+		clients:   make(map[*Client]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// options returns a copy of the proxy's current configuration.
+func (proxy *Proxy) options() Options {
+	proxy.optsmu.RLock()
+	defer proxy.optsmu.RUnlock()
+
+	return proxy.opts
+}
+
+// ruleset returns the proxy's current rules.
+func (proxy *Proxy) ruleset() *ruleset {
+	proxy.optsmu.RLock()
+	defer proxy.optsmu.RUnlock()
+
+	return proxy.rules
+}
+
+// rewrite applies the project's match/replace rules to a message of an exchange
+// and records a hit for each rule that matched. The framing of a message whose
+// body was rewritten is fixed, so that the receiver reads the rewritten body.
+func (proxy *Proxy) rewrite(buf *buffer.Buffer, direction Direction, req *http.Request) *buffer.Buffer {
+	filters := proxy.ruleset().filters(direction)
+	if len(filters) == 0 {
+		return buf
 	}
+
+	head := direction == DirectionResponse && req.Method == http.MethodHead
+	raw, hits := filterMessage(buf.Buffer(), filters, head)
+
+	for _, id := range hits {
+		if err := proxy.db.Replace.IncrementHits(id); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return buffer.NewBufferFrom(raw, len(raw))
 }
 
-// Spawn creates a new TCP proxy listener and accepts connections from the client.
-// The connections accepted by the listener will have requests and responses that
-// can be stalled and modified at the control panel. The listener handles all
-// connections synchronously to avoid race conditions.
-func (proxy *Proxy) Spawn() {
+// certCache returns the proxy's current leaf certificates.
+func (proxy *Proxy) certCache() *certs.Cache {
+	proxy.optsmu.RLock()
+	defer proxy.optsmu.RUnlock()
+
+	return proxy.certs
+}
+
+// SetAuthority replaces the root certificate that leaf certificates are signed
+// with, as when the project's root certificate is regenerated. The leaf
+// certificates signed with the previous one are discarded.
+func (proxy *Proxy) SetAuthority(ca *certs.Authority) {
+	proxy.optsmu.Lock()
+	defer proxy.optsmu.Unlock()
+
+	proxy.certs = certs.NewCache(ca)
+}
+
+// setStall enables or disables stalling requests and responses. The change is
+// saved to the project's settings, so that reloading the proxy keeps it.
+func (proxy *Proxy) setStall(stall bool) error {
+	s, err := proxy.db.Settings.Fetch(proxy.projectId)
+	if err != nil {
+		return err
+	}
+
+	s.Stall = stall
+	if err = proxy.db.Settings.Save(s); err != nil {
+		return err
+	}
+
+	proxy.optsmu.Lock()
+	released := proxy.opts.Stall && !stall
+	proxy.opts.Stall = stall
+	proxy.optsmu.Unlock()
+
+	if released {
+		proxy.queue.release()
+	}
+
+	return nil
+}
+
+// Reload applies the project's interception settings and rules to the running
+// proxy. Changes to the listeners take effect once the proxy is restarted.
+func (proxy *Proxy) Reload() error {
+	s, err := proxy.db.Settings.Fetch(proxy.projectId)
+	if err != nil {
+		return err
+	}
+
+	rules, err := proxy.loadRules()
+	if err != nil {
+		return err
+	}
+
+	opts := optionsFrom(s)
+
+	proxy.optsmu.Lock()
+	released := proxy.opts.Stall && !opts.Stall
+	proxy.opts.InterceptClient = opts.InterceptClient
+	proxy.opts.InterceptServer = opts.InterceptServer
+	proxy.opts.Stall = opts.Stall
+	proxy.opts.PassOutOfScope = opts.PassOutOfScope
+	proxy.opts.DropAction = opts.DropAction
+	proxy.opts.DropStatus = opts.DropStatus
+	proxy.opts.FixFraming = opts.FixFraming
+	proxy.rules = rules
+	proxy.optsmu.Unlock()
+
+	// Stalled items are only forwarded when interception is turned off.
+	if released {
+		proxy.queue.release()
+	}
+
+	return nil
+}
+
+// Spawn creates the TCP proxy listeners configured in the project's settings and
+// accepts connections from the client in the background until the proxy is closed.
+// The connections accepted by the listeners will have requests and responses that
+// can be stalled and modified at the control panel. Each connection is handled in
+// its own goroutine, so a stalled request does not block other connections.
+func (proxy *Proxy) Spawn() error {
 	var (
-		listener net.Listener
-		opts     Options
-		err      error
+		s   *settings.Settings
+		ca  *certs.Authority
+		err error
 	)
 
-	// Set default options for interception
-	opts = Options{
-		ListenPort:      DefaultPort,
-		InterceptClient: true,
-		InterceptServer: true,
-		Stall:           false,
+	if s, err = proxy.db.Settings.Fetch(proxy.projectId); err != nil {
+		return err
 	}
+	proxy.opts = optionsFrom(s)
 
-	listener, err = net.Listen("tcp", fmt.Sprintf(":%d", opts.ListenPort))
-	if err != nil {
-		log.Fatal(err)
+	if proxy.rules, err = proxy.loadRules(); err != nil {
+		return err
 	}
 
-	// FIXME: Would a mutex be required for accessing this critical section?
-	go func() {
-		for {
-			cmd := <-proxy.cmd
-			switch cmd.Type {
-			case ProxyCmdStart:
-				opts.Stall = true
-				fmt.Printf("Stall: on\n")
-			case ProxyCmdStop:
-				opts.Stall = false
-				fmt.Printf("Stall: off\n")
-			case ProxyCmdForward, ProxyCmdDrop:
-				proxy.intcmd <- cmd
-			default:
+	// Load the project's root certificate for signing the leaf certificates
+	// presented to clients during TLS interception.
+	if ca, err = proxy.db.Projects.FetchAuthority(proxy.projectId); err != nil {
+		return err
+	}
+	proxy.certs = certs.NewCache(ca)
+
+	for _, l := range proxy.opts.Listeners {
+		var listener net.Listener
+
+		if listener, err = net.Listen("tcp", l.Address()); err != nil {
+			// Close the listeners that were already started.
+			for _, listener = range proxy.listeners {
+				listener.Close()
 			}
+
+			return err
 		}
-	}()
+
+		proxy.listeners = append(proxy.listeners, listener)
+	}
+
+	proxy.started = time.Now()
+
+	for i, listener := range proxy.listeners {
+		proxy.done.Add(1)
+		go proxy.accept(listener, proxy.opts.Listeners[i])
+	}
+
+	return nil
+}
+
+// accept accepts connections from a listener until it is closed. Connections
+// are handled as the listener's mode says.
+func (proxy *Proxy) accept(listener net.Listener, l Listener) {
+	handle := proxy.HandleRequest
+
+	switch l.Mode {
+	case ModeSOCKS5:
+		handle = proxy.HandleSOCKS
+	case ModeInvisible:
+		handle = proxy.HandleInvisible
+	case ModeReverse:
+		handle = func(conn net.Conn) error {
+			return proxy.HandleReverse(conn, l)
+		}
+	}
+
+	defer proxy.done.Done()
 
 	for {
-		var conn net.Conn
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Println(err)
+			}
+
+			return
+		}
+
+		proxy.track(conn, true)
+
+		go func(conn net.Conn) {
+			defer proxy.track(conn, false)
+			defer conn.Close()
+
+			if err := handle(conn); err != nil {
+				log.Println(err)
+			}
+		}(conn)
+	}
+}
 
-		conn, err = listener.Accept()
+// track adds or removes an open client connection.
+func (proxy *Proxy) track(conn net.Conn, open bool) {
+	proxy.connsmu.Lock()
+	defer proxy.connsmu.Unlock()
+
+	if open {
+		proxy.conns[conn] = struct{}{}
+	} else {
+		delete(proxy.conns, conn)
+	}
+}
+
+// Close stops the proxy listeners and closes every open client connection and
+// attached control panel. Stalled requests and responses are forwarded unmodified.
+func (proxy *Proxy) Close() error {
+	var err error
+
+	for _, listener := range proxy.listeners {
+		if cerr := listener.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	proxy.done.Wait()
+
+	proxy.queue.release()
+
+	proxy.clientsmu.Lock()
+	for c := range proxy.clients {
+		c.close()
+	}
+	proxy.clientsmu.Unlock()
+
+	proxy.connsmu.Lock()
+	for conn := range proxy.conns {
+		conn.Close()
+	}
+	proxy.connsmu.Unlock()
+
+	return err
+}
+
+// Status returns the current state of the proxy.
+func (proxy *Proxy) Status() Status {
+	var status Status
+
+	status = Status{
+		Running: true,
+		Started: proxy.started,
+		Stall:   proxy.options().Stall,
+		Pending: proxy.queue.len(),
+	}
+
+	for _, listener := range proxy.listeners {
+		status.Addresses = append(status.Addresses, listener.Addr().String())
+	}
+
+	proxy.clientsmu.Lock()
+	status.Clients = len(proxy.clients)
+	proxy.clientsmu.Unlock()
+
+	proxy.connsmu.Lock()
+	status.Connections = len(proxy.conns)
+	proxy.connsmu.Unlock()
+
+	return status
+}
+
+// Validate validates a command received from the control panel, including
+// whether the stalled item it refers to is still pending.
+func (proxy *Proxy) Validate(cmd *ProxyCmd) error {
+	if err := cmd.Validate(); err != nil {
+		return err
+	}
+
+	if cmd.ID != "" && !proxy.queue.has(cmd.ID) {
+		return ErrUnknownItem
+	}
+
+	return nil
+}
+
+// Command processes a command received from a control panel. Replies are sent
+// back to the control panel the command was received from.
+func (proxy *Proxy) Command(c *Client, cmd ProxyCmd) error {
+	switch cmd.Type {
+	case ProxyCmdStart:
+		if err := proxy.setStall(true); err != nil {
+			return err
+		}
+	case ProxyCmdStop:
+		if err := proxy.setStall(false); err != nil {
+			return err
+		}
+	case ProxyCmdForward, ProxyCmdDrop:
+		if !proxy.queue.pop(cmd) {
+			return ErrUnknownItem
+		}
+	case ProxyCmdEdit:
+		data, err := cmd.Payload()
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		defer conn.Close()
 
-		if err = proxy.HandleRequest(conn, &opts); err != nil {
-			log.Println(err)
+		if !proxy.queue.edit(cmd.ID, data) {
+			return ErrUnknownItem
 		}
+	case ProxyCmdList:
+		return c.Send(&ProxyCmd{
+			Type:  ProxyCmdQueue,
+			Items: proxy.queue.list(),
+		})
+	default:
 	}
+
+	return nil
 }
 
-// stall takes intercepted data and sends it to the client's WebSocket connection and blocks
-// until a command is received. If the command type if ProxyCmdForward, the original request
-// is compared to the command data. If the two buffers match, the edited flag will be set.
-// If the command type is ProxyCmdDrop, return an error.
-func (proxy *Proxy) stall(stalled *buffer.Buffer, edited *bool) (*buffer.Buffer, error) {
+// dropError is returned by stall when an item is dropped. It carries the drop
+// command, which says how the client is answered.
+type dropError struct {
+	cmd ProxyCmd
+}
+
+func (e *dropError) Error() string {
+	return ErrDropped.Error()
+}
+
+// Is makes a dropError match ErrDropped.
+func (e *dropError) Is(target error) bool {
+	return target == ErrDropped
+}
+
+// stall takes intercepted data and sends it to the control panels attached to the
+// proxy and blocks until a command is received. If the command type if ProxyCmdForward,
+// the original request is compared to the command data. If the two buffers differ, the
+// edited flag will be set, and the framing of the edited data is fixed if the command
+// or the project's settings ask for it. If the command type is ProxyCmdDrop, the stalled
+// data is returned with a *dropError. Data is forwarded without stalling when no control
+// panel is attached.
+func (proxy *Proxy) stall(
+	stalled *buffer.Buffer,
+	direction Direction,
+	req *http.Request,
+	edited *bool,
+) (*buffer.Buffer, error) {
 	var (
+		item      *interception
 		cmd       ProxyCmd
-		msg       ProxyCmd
-		payload   []byte
+		ok        bool
 		forwarded *buffer.Buffer
-		err       error
 	)
 
-	msg = ProxyCmd{
-		Type: ProxyCmdStall,
-		Data: string(stalled.Buffer()),
-	}
+	item = proxy.queue.push(stalled, direction, req.URL.Host, "")
 
-	if payload, err = json.Marshal(&msg); err != nil {
-		return nil, err
-	}
-
-	if err = proxy.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
-		return nil, err
+	if cmd, ok = proxy.wait(item); !ok {
+		return stalled, nil
 	}
 
-	cmd = <-proxy.intcmd
 	if cmd.Type == ProxyCmdForward {
-		if forwarded = buffer.NewBufferFrom([]byte(cmd.Data), len(cmd.Data)); forwarded == nil {
-			return nil, ErrNilBuffer
+		data, err := cmd.Payload()
+		if err != nil {
+			return nil, err
 		}
 
-		if bytes.Compare(forwarded.Buffer(), stalled.Buffer()) != 0 {
+		if bytes.Compare(data, stalled.Buffer()) != 0 {
 			*edited = true
+
+			fix := proxy.options().FixFraming
+			if cmd.FixFraming != nil {
+				fix = *cmd.FixFraming
+			}
+
+			if fix {
+				head := direction == DirectionResponse && req.Method == http.MethodHead
+				data = fixFraming(data, head)
+			}
+		}
+
+		if forwarded = buffer.NewBufferFrom(data, len(data)); forwarded == nil {
+			return nil, ErrNilBuffer
 		}
 
 		return forwarded, nil
 	}
 
-	return nil, ErrDropped
+	return stalled, &dropError{cmd}
+}
+
+// wait sends a queued interception to the control panels attached to the proxy
+// and blocks until a command is received for it. If no control panel is attached,
+// the interception is removed from the queue and false is returned at once.
+func (proxy *Proxy) wait(item *interception) (ProxyCmd, bool) {
+	msg := item.cmd()
+	if !proxy.broadcast(&msg) {
+		proxy.queue.remove(item)
+		return ProxyCmd{}, false
+	}
+
+	return <-item.reply, true
+}
+
+// drop answers the client after an item was dropped, as the drop command or the
+// project's settings say, and commits the exchange with the dropped state. The
+// client connection is closed afterwards, since a canned response may not be
+// framed correctly.
+func (proxy *Proxy) drop(conn net.Conn, d *httpdata, direction Direction, cmd ProxyCmd) error {
+	var (
+		opts     Options
+		action   DropAction
+		status   int
+		response *buffer.Buffer
+		err      error
+	)
+
+	opts = proxy.options()
+
+	// Canned responses are only carried by commands, so a default that asks for
+	// one closes the connection.
+	if action = cmd.Action; action == "" && opts.DropAction.ValidDefault() {
+		action = opts.DropAction
+	}
+
+	if status = cmd.Status; status == 0 {
+		status = opts.DropStatus
+	}
+
+	switch action {
+	case DropStatus:
+		response = errorResponse(status, fmt.Errorf("the %s was dropped by the proxy", direction))
+	case DropResponse:
+		data, _ := cmd.Payload()
+		response = buffer.NewBufferFrom(data, len(data))
+	}
+
+	d.State = requests.StateDropped
+
+	// A dropped request has no response from the server, so the response sent
+	// to the client is recorded in its place.
+	if direction == DirectionRequest && response != nil {
+		d.RawResponse = response
+		d.ResponseTime = time.Now()
+
+		if d.Response, err = readResponse(d.Request, response); err != nil {
+			d.Response = nil
+		}
+	}
+
+	if err = proxy.commit(d); err != nil {
+		log.Println(err)
+	}
+
+	if response == nil {
+		return nil
+	}
+
+	return response.Send(conn)
 }
 
 type httpdata struct {
@@ -162,58 +631,138 @@ type httpdata struct {
 	Response         *http.Response
 	RawRequest       *buffer.Buffer
 	RawResponse      *buffer.Buffer
+	OriginalRequest  *buffer.Buffer // Request before it was edited at the control panel
+	OriginalResponse *buffer.Buffer // Response before it was edited at the control panel
 	Elapsed          time.Duration
+	Timing           timing // Breakdown of the time taken by the exchange
+	IPAddr           string // Internet address the target server was dialed to
+	Upstream         string // Upstream proxy the request was sent through, if any
+	RequestID        string // Unique ID the request was recorded with
+	Protocol         string // Protocol version the request was sent with
 	RequestTime      time.Time
 	ResponseTime     time.Time
 	IsRequestEdited  bool
 	IsResponseEdited bool
+	Scheme           string // Scheme of requests that could not be parsed
+	State            string // State of the exchange
+	Err              error  // Error that interrupted the exchange
+	ErrorKind        string // Classification of the error, if the target was not reached
+	InScope          bool   // Whether the target is in the project's scope
+	Passthrough      bool   // Whether the exchange is neither logged nor intercepted
 }
 
 // commit inserts the data contained in the passed httpdata struct into the
-// appropriate tables in the database.
+// appropriate tables in the database. Exchanges that were interrupted may
+// be missing the parsed request or the response. Exchanges passed through
+// the proxy are not recorded. The metadata of edited messages is taken from
+// the edited bytes that are recorded as forwarded, not from the originals.
 func (proxy *Proxy) commit(d *httpdata) error {
 	var (
 		requestId      string
 		responseId     string
+		request        *http.Request
 		requestRecord  requests.Request
 		responseRecord responses.Response
 		err            error
 	)
 
+	if d.Passthrough {
+		return nil
+	}
+
+	if request = d.Request; request != nil && d.OriginalRequest != nil {
+		if edited, err := reparseRequest(d.RawRequest, request); err == nil {
+			request = edited
+		}
+	}
+
 	requestId = uuid.New().String()
-	responseId = uuid.New().String()
+
+	if d.RawResponse != nil {
+		responseId = uuid.New().String()
+	}
 
 	requestRecord = requests.Request{
 		ID:         requestId,
 		ProjectID:  proxy.projectId,
 		ResponseID: responseId,
-		Method:     d.Request.Method,
-		Domain:     d.Request.URL.Host,
-		IPAddr:     d.Request.URL.Host, // TODO(Brett) Record the IP address of hosts
-		URL:        d.Request.URL.RequestURI(),
+		Scheme:     d.Scheme,
 		Length:     int64(d.RawRequest.Size()),
 		Edited:     d.IsRequestEdited,
 		Timestamp:  d.RequestTime,
 		Comment:    "", // TODO(Brett): Implement comments
-		Raw:        string(d.RawRequest.Buffer()),
+		InScope:    d.InScope,
+		State:      d.State,
+		Raw:        d.RawRequest.Buffer(),
+	}
+
+	if d.OriginalRequest != nil {
+		requestRecord.Original = d.OriginalRequest.Buffer()
+	}
+
+	if request != nil {
+		requestRecord.Method = request.Method
+		requestRecord.Scheme = request.URL.Scheme
+		requestRecord.Domain = request.URL.Host
+		requestRecord.IPAddr = d.IPAddr
+		requestRecord.Upstream = d.Upstream
+		requestRecord.URL = request.URL.RequestURI()
+		requestRecord.Protocol = d.Protocol
+	}
+
+	if d.Err != nil {
+		requestRecord.Error = d.Err.Error()
+		requestRecord.ErrorKind = d.ErrorKind
 	}
 
 	if _, err = proxy.db.Requests.Insert(&requestRecord); err != nil {
 		return err
 	}
+	d.RequestID = requestId
+
+	if d.RawResponse == nil {
+		return nil
+	}
 
 	responseRecord = responses.Response{
 		ID:        responseId,
 		ProjectID: proxy.projectId,
 		RequestID: requestId,
-		Status:    int16(d.Response.StatusCode),
 		Length:    int64(d.RawResponse.Size()),
 		Edited:    d.IsResponseEdited,
 		Elapsed:   int64(d.Elapsed),
+		DNSTime:   int64(d.Timing.DNS),
+		Connect:   int64(d.Timing.Connect),
+		TLSTime:   int64(d.Timing.TLS),
+		TTFB:      int64(d.Timing.TTFB),
+		Total:     int64(d.Timing.Total),
 		Timestamp: d.ResponseTime,
-		Mimetype:  "", // TODO(Brett): Record response body mime-types
 		Comment:   "", // TODO(Brett): Implement comments
-		Raw:       string(d.RawResponse.Buffer()),
+		Raw:       d.RawResponse.Buffer(),
+	}
+
+	if d.OriginalResponse != nil {
+		responseRecord.Original = d.OriginalResponse.Buffer()
+	}
+
+	// An edited response that can't be parsed has no status.
+	if d.Response != nil && d.OriginalResponse == nil {
+		responseRecord.Status = int16(d.Response.StatusCode)
+	}
+
+	// The status, body and mime-type are taken from the raw bytes being recorded.
+	if request != nil {
+		if res, err := readResponse(request, d.RawResponse); err == nil {
+			responseRecord.Status = int16(res.StatusCode)
+
+			if responseRecord.Body, err = decodeBody(res); err != nil {
+				responseRecord.BodyError = err.Error()
+			}
+
+			responseRecord.Mimetype = mimetype(res, responseRecord.Body)
+		} else {
+			responseRecord.BodyError = err.Error()
+		}
 	}
 
 	if _, err = proxy.db.Responses.Insert(&responseRecord); err != nil {
@@ -225,105 +774,583 @@ func (proxy *Proxy) commit(d *httpdata) error {
 
 // HandleRequest handles requests and response by acting as a middle-man.
 // Requests are received from the client and forwarded to their destination.
-func (proxy *Proxy) HandleRequest(conn net.Conn, opts *Options) error {
+// CONNECT requests are accepted and the tunneled TLS connection is intercepted.
+func (proxy *Proxy) HandleRequest(conn net.Conn) error {
+	return proxy.serve(conn, nil)
+}
+
+// HandleInvisible handles a connection to an invisible listener, from a client
+// that isn't aware of the proxy and sends its requests as if to the target server.
+// The target of TLS connections is taken from the server name sent by the client,
+// and the target of plain HTTP requests from their Host header.
+func (proxy *Proxy) HandleInvisible(conn net.Conn) error {
+	peeked, err := peek(conn, idleTimeout)
+	if err != nil {
+		return err
+	}
+
+	conn = &bufferedConn{conn, peeked}
+
+	if isTLS(peeked) {
+		return proxy.interceptTLS(conn, "")
+	}
+
+	return proxy.serve(conn, &tunnel{scheme: "http"})
+}
+
+// HandleReverse handles a connection to a reverse listener, whose requests are
+// all forwarded to the listener's target, with their Host header rewritten if
+// the listener says so. Clients may connect with or without TLS, whatever the
+// scheme of the target.
+func (proxy *Proxy) HandleReverse(conn net.Conn, l Listener) error {
 	var (
-		clientRequest  *buffer.Buffer
-		proxyRequest   *buffer.Buffer
+		peeked  []byte
+		tlsConn *tls.Conn
+		err     error
+	)
+
+	if l.Target == nil {
+		return ErrTarget
+	}
+
+	if peeked, err = peek(conn, idleTimeout); err != nil {
+		return err
+	}
+
+	conn = &bufferedConn{conn, peeked}
+
+	if isTLS(peeked) {
+		if tlsConn, err = proxy.terminateTLS(conn, l.Target.Hostname()); err != nil {
+			return err
+		}
+		defer tlsConn.Close()
+
+		conn = tlsConn
+	}
+
+	return proxy.serve(conn, &tunnel{l.Target.Scheme, l.Target.Host, l.HostHeader})
+}
+
+// tunnel is the destination of a client connection that was opened through a
+// CONNECT request or a SOCKS5, invisible or reverse listener. Requests sent
+// through a tunnel are in origin-form, so their target is taken from it.
+type tunnel struct {
+	scheme     string // Scheme the requests are sent with
+	host       string // host:port the requests are sent to, or empty for their Host header
+	hostHeader string // Host header the requests are sent with, if rewritten
+}
+
+// route sets the target of a request received through the tunnel.
+func (t *tunnel) route(req *http.Request) error {
+	req.URL.Scheme = t.scheme
+
+	if req.URL.Host = t.host; req.URL.Host == "" {
+		req.URL.Host = req.Host
+	}
+
+	if req.URL.Host == "" {
+		return ErrNoHost
+	}
+
+	if t.hostHeader != "" {
+		req.Host = t.hostHeader
+	}
+
+	return nil
+}
+
+// serve handles the requests received on a client connection until either side
+// closes it. Several requests may be sent over the same connection, and the
+// connections to target servers are reused between them. The tunnel is the
+// destination the connection was opened to, if any.
+func (proxy *Proxy) serve(conn net.Conn, tunnel *tunnel) error {
+	var (
+		reader    *buffer.Reader
+		ups       upstreams
+		keepAlive bool
+		err       error
+	)
+
+	// Clients that negotiated HTTP/2 send streams rather than requests.
+	if tlsConn, ok := conn.(*tls.Conn); ok && tunnel != nil &&
+		tlsConn.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS {
+		return proxy.serveH2(conn, tunnel)
+	}
+
+	reader = buffer.NewReader(conn)
+
+	ups = make(upstreams)
+	defer ups.close()
+
+	for {
+		var (
+			clientRequest *buffer.Buffer
+			httpRequest   *http.Request
+		)
+
+		// Read client request
+		if clientRequest, err = reader.ReadRequest(); err != nil {
+			if err == io.EOF || clientRequest.Size() == 0 {
+				return nil
+			}
+
+			return proxy.handleMalformedRequest(conn, clientRequest, tunnel, err)
+		}
+
+		if httpRequest, err = readRequest(clientRequest); err != nil {
+			return proxy.handleMalformedRequest(conn, clientRequest, tunnel, err)
+		}
+
+		if tunnel == nil && httpRequest.Method == http.MethodConnect {
+			return proxy.handleConnect(&bufferedConn{conn, reader.Buffered()}, httpRequest)
+		}
+
+		if tunnel != nil {
+			if err = tunnel.route(httpRequest); err != nil {
+				return proxy.handleMalformedRequest(conn, clientRequest, tunnel, err)
+			}
+
+			if tunnel.hostHeader != "" {
+				clientRequest = rewriteHost(clientRequest, tunnel.hostHeader)
+			}
+		}
+
+		keepAlive, err = proxy.handleExchange(conn, reader, ups, clientRequest, httpRequest)
+		if err != nil || !keepAlive {
+			return err
+		}
+	}
+}
+
+// handleMalformedRequest records a request that could not be read or parsed, and
+// answers it with a 400 Bad Request generated by the proxy. The connection can't
+// be used for further requests, since the end of the malformed one is unknown.
+func (proxy *Proxy) handleMalformedRequest(
+	conn net.Conn,
+	clientRequest *buffer.Buffer,
+	tunnel *tunnel,
+	err error,
+) error {
+	var dbdata httpdata
+
+	dbdata = httpdata{
+		RawRequest:  clientRequest,
+		RequestTime: time.Now(),
+		Scheme:      "http",
+		State:       requests.StateParseError,
+		Err:         fmt.Errorf("malformed request: %w", err),
+	}
+
+	target := &url.URL{Scheme: dbdata.Scheme}
+	if tunnel != nil {
+		dbdata.Scheme = tunnel.scheme
+		target = &url.URL{Scheme: tunnel.scheme, Host: tunnel.host}
+	}
+	dbdata.InScope = proxy.ruleset().inScope(target)
+
+	if err = proxy.commit(&dbdata); err != nil {
+		log.Println(err)
+	}
+
+	return errorResponse(http.StatusBadRequest, dbdata.Err).Send(conn)
+}
+
+// bufferedConn is a net.Conn with bytes that were read ahead from it before
+// they could be handled.
+type bufferedConn struct {
+	net.Conn
+	buffered []byte
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	if len(c.buffered) > 0 {
+		n := copy(p, c.buffered)
+		c.buffered = c.buffered[n:]
+
+		return n, nil
+	}
+
+	return c.Conn.Read(p)
+}
+
+// handleConnect accepts a CONNECT request and handles the connection tunneled
+// through it like one tunneled by a SOCKS5 client. Clients mostly tunnel TLS
+// through CONNECT, but WebSocket clients also tunnel plain HTTP through it.
+func (proxy *Proxy) handleConnect(conn net.Conn, connectRequest *http.Request) error {
+	if _, err := conn.Write([]byte(connectEstablished)); err != nil {
+		return err
+	}
+
+	return proxy.handleTunnel(conn, connectRequest.URL.Host)
+}
+
+// interceptTLS terminates a TLS connection tunneled to addr with a leaf
+// certificate for the server name sent by the client, or for the host of addr.
+// The decrypted requests are then handled like plain HTTP requests, except that
+// they are sent to addr over TLS. If addr is empty, the requests are sent to the
+// server name on port 443, or to their Host header without a server name.
+func (proxy *Proxy) interceptTLS(conn net.Conn, addr string) error {
+	var (
+		host    string
+		tlsConn *tls.Conn
+		err     error
+	)
+
+	if host, _, err = net.SplitHostPort(addr); err != nil {
+		host = addr
+	}
+
+	// Clients that send no server name connected to the listener's address.
+	if host == "" {
+		host, _, _ = net.SplitHostPort(conn.LocalAddr().String())
+	}
+
+	if tlsConn, err = proxy.terminateTLS(conn, host); err != nil {
+		return err
+	}
+	defer tlsConn.Close()
+
+	if name := tlsConn.ConnectionState().ServerName; addr == "" && name != "" {
+		addr = net.JoinHostPort(name, "443")
+	}
+
+	return proxy.serve(tlsConn, &tunnel{scheme: "https", host: addr})
+}
+
+// terminateTLS performs the server side of a TLS handshake with a client, with
+// a leaf certificate for the server name sent by the client, or for the fallback
+// host if there is none. HTTP/2 is offered to the client.
+func (proxy *Proxy) terminateTLS(conn net.Conn, fallback string) (*tls.Conn, error) {
+	config := proxy.certCache().Config(fallback)
+	config.NextProtos = nextProtos
+
+	tlsConn := tls.Server(conn, config)
+
+	if err := tlsConn.Handshake(); err != nil {
+		tlsConn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// handleTunnel handles a connection tunneled to addr by a SOCKS5 client or a
+// CONNECT request. The first bytes sent by the client tell its protocol: TLS
+// connections are intercepted, and plain HTTP requests are sent to addr. Other
+// protocols, and clients that wait for the server to speak first, are relayed
+// to addr without being recorded.
+func (proxy *Proxy) handleTunnel(conn net.Conn, addr string) error {
+	var (
+		peeked []byte
+		err    error
+	)
+
+	if peeked, err = peek(conn, detectTimeout); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+		return err
+	}
+
+	conn = &bufferedConn{conn, peeked}
+
+	switch {
+	case isTLS(peeked):
+		return proxy.interceptTLS(conn, addr)
+	case isHTTP(peeked):
+		return proxy.serve(conn, &tunnel{scheme: "http", host: addr})
+	}
+
+	return proxy.relay(conn, addr)
+}
+
+// relay copies data between a client connection and addr in both directions,
+// until either side closes its connection. The connection to addr is made
+// through an upstream proxy if a rule says so.
+func (proxy *Proxy) relay(conn net.Conn, addr string) error {
+	var (
+		target = &http.Request{URL: &url.URL{Host: addr}}
+		up     *upstream
+		err    error
+	)
+
+	if up, err = dial(target, proxy.ruleset().via(target.URL.Hostname())); err != nil {
+		return err
+	}
+	defer up.conn.Close()
+
+	return pipe(conn, up.conn)
+}
+
+// pipe copies data between a client connection and a server connection in both
+// directions, until either side closes its connection.
+func pipe(client net.Conn, server net.Conn) error {
+	go func() {
+		io.Copy(server, client)
+		server.Close()
+	}()
+
+	_, err := io.Copy(client, server)
+
+	return err
+}
+
+// roundTrip sends a request to its target server and reads the response. If a
+// reused connection was closed by the server while it was idle, the request is
+// retried once on a new connection. If the response can't be read, the bytes
+// that were read are returned with the error. The address dialed and the time
+// taken by each step are recorded in the exchange data.
+func (proxy *Proxy) roundTrip(
+	ups upstreams,
+	proxyRequest *buffer.Buffer,
+	httpRequest *http.Request,
+	d *httpdata,
+) (*buffer.Buffer, error) {
+	var (
+		up             *upstream
+		reused         bool
 		serverResponse *buffer.Buffer
-		httpRequest    *http.Request
-		dbdata         httpdata
-		hostname       string
+		start          time.Time
 		timer          time.Time
 		err            error
 	)
 
-	dbdata = httpdata{}
+	for {
+		start = time.Now()
 
-	// Read client request
-	clientRequest = buffer.NewBuffer()
-	if err = clientRequest.Recv(conn); err != nil {
-		if err != io.EOF {
-			return err
+		// Connect to the target server, through an upstream proxy if a
+		// rule says so.
+		via := proxy.ruleset().via(httpRequest.URL.Hostname())
+		d.Upstream = proxyURL(via)
+
+		if up, reused, err = ups.get(httpRequest, via); err != nil {
+			return nil, err
 		}
 
-		return nil
-	}
+		d.IPAddr = up.ip
+		d.Upstream = up.via
+		d.Timing = timing{}
+		if !reused {
+			d.Timing = up.dialed
+		}
+
+		// Requests rendered from HTTP/2 streams are sent as HTTP/1.1 to target
+		// servers that don't speak HTTP/2.
+		if up.h2 == nil && httpRequest.ProtoMajor == 2 {
+			proxyRequest = downgrade(proxyRequest)
+			d.RawRequest = proxyRequest
+			d.Protocol = protoHTTP11
+		}
+
+		// Proxy the request to its destination, in the protocol the target
+		// server negotiated.
+		if up.h2 != nil {
+			d.Protocol = protoHTTP2
+			timer = time.Now()
+			serverResponse, err = roundTripH2(up, proxyRequest, httpRequest, d)
+		} else if err = proxyRequest.Send(up.conn); err == nil {
+			d.RequestTime = time.Now()
+			timer = time.Now()
 
-	httpRequest = readRequest(clientRequest)
+			// Read the server response.
+			up.first.reset()
+			up.conn.SetReadDeadline(time.Now().Add(responseTimeout))
+			serverResponse, err = up.reader.ReadResponse(httpRequest.Method)
+			up.conn.SetReadDeadline(time.Time{})
+
+			if !up.first.at.IsZero() {
+				d.Timing.TTFB = up.first.at.Sub(timer)
+			}
+		}
+
+		if err == nil {
+			d.ResponseTime = time.Now()
+			d.Elapsed = d.ResponseTime.Sub(timer)
+			d.Timing.Total = d.ResponseTime.Sub(start)
+
+			return serverResponse, nil
+		}
 
-	// FIXME: Discard all CONNECT requests
-	// Let's not deal with HTTPS yet.
-	if httpRequest.Method == http.MethodConnect {
-		return ErrTmpNoOptions
+		ups.discard(httpRequest)
+
+		// A response that is malformed is returned with the bytes that were
+		// read, rather than retried.
+		if serverResponse != nil && serverResponse.Size() > 0 {
+			d.ResponseTime = time.Now()
+			d.Elapsed = d.ResponseTime.Sub(timer)
+			d.Timing.Total = d.ResponseTime.Sub(start)
+
+			return serverResponse, err
+		}
+
+		if !reused {
+			return nil, err
+		}
 	}
+}
+
+// handleExchange forwards a single request from the client to its target
+// server, and the server's response back to the client. Both can be stalled
+// at the control panel before they are sent. It reports whether the client
+// connection can be kept alive for another request. If the response switches
+// protocols, the connection is taken over until either side closes it.
+func (proxy *Proxy) handleExchange(
+	conn net.Conn,
+	reader *buffer.Reader,
+	ups upstreams,
+	clientRequest *buffer.Buffer,
+	httpRequest *http.Request,
+) (bool, error) {
+	var (
+		proxyRequest   *buffer.Buffer
+		serverResponse *buffer.Buffer
+		dbdata         httpdata
+		err            error
+	)
+
+	dbdata = httpdata{State: requests.StateComplete, Protocol: httpRequest.Proto}
+	dbdata.InScope = proxy.ruleset().inScope(httpRequest.URL)
+
+	// Out-of-scope traffic may pass through without being logged or intercepted.
+	dbdata.Passthrough = !dbdata.InScope && proxy.options().PassOutOfScope
 
 	// Send the client's request to the target server.
 	if proxyRequest, err = parseProxyRequest(clientRequest, httpRequest); err != nil {
-		return err
+		return false, err
 	}
+	proxyRequest = proxy.rewrite(proxyRequest, DirectionRequest, httpRequest)
 
-	// Stall requests
-	if opts.InterceptClient && opts.Stall {
-		proxyRequest, err = proxy.stall(proxyRequest, &dbdata.IsRequestEdited)
+	// Stall requests matching the intercept rules
+	if opts := proxy.options(); opts.InterceptClient && opts.Stall && !dbdata.Passthrough &&
+		proxy.ruleset().interceptRequest(httpRequest) {
+		stalled := proxyRequest
+
+		proxyRequest, err = proxy.stall(
+			proxyRequest,
+			DirectionRequest,
+			httpRequest,
+			&dbdata.IsRequestEdited,
+		)
 		if err != nil {
-			if err != ErrDropped {
-				return err
+			var dropped *dropError
+
+			if !errors.As(err, &dropped) {
+				return false, err
 			}
 
-			return nil
+			dbdata.Request = httpRequest
+			dbdata.RawRequest = proxyRequest
+			dbdata.RequestTime = time.Now()
+
+			return false, proxy.drop(conn, &dbdata, DirectionRequest, dropped.cmd)
+		}
+
+		// The edited request is sent, and its response read, as it was edited.
+		if dbdata.IsRequestEdited {
+			dbdata.OriginalRequest = stalled
+
+			if edited, err := reparseRequest(proxyRequest, httpRequest); err == nil {
+				httpRequest = edited
+				dbdata.Protocol = httpRequest.Proto
+				answering(conn, httpRequest)
+			}
 		}
 	}
 
 	dbdata.Request = httpRequest
 	dbdata.RawRequest = proxyRequest
 
-	// Ensure that the hostname format is always host:port.
-	hostname = httpRequest.Host
-	if httpRequest.URL.Port() == "" {
-		hostname = hostname + ":80"
+	// A target server that can't be reached is recorded with the classification
+	// of the error, and the client is sent a 502 Bad Gateway describing it.
+	serverResponse, err = proxy.roundTrip(ups, proxyRequest, httpRequest, &dbdata)
+	if err != nil && (serverResponse == nil || serverResponse.Size() == 0) {
+		kind := classify(err)
+
+		if dbdata.RequestTime.IsZero() {
+			dbdata.RequestTime = time.Now()
+		}
+
+		dbdata.State = requests.StateFailed
+		dbdata.ErrorKind = kind
+		dbdata.Err = fmt.Errorf("could not reach %s: %s: %w", targetAddr(httpRequest), errorKinds[kind], err)
+
+		if err = proxy.commit(&dbdata); err != nil {
+			log.Println(err)
+		}
+
+		return false, errorResponse(http.StatusBadGateway, dbdata.Err).Send(conn)
 	}
 
-	// Connect to the target server.
-	proxyConn, err := net.Dial("tcp", hostname)
-	if err != nil {
-		return err
+	if err == nil {
+		serverResponse = proxy.rewrite(serverResponse, DirectionResponse, httpRequest)
 	}
-	defer proxyConn.Close()
 
-	// Proxy the request to its destination.
-	if err = proxyRequest.Send(proxyConn); err != nil {
-		return err
+	dbdata.RawResponse = serverResponse
+
+	if err == nil {
+		dbdata.Response, err = readResponse(httpRequest, serverResponse)
 	}
 
-	dbdata.RequestTime = time.Now()
-	timer = time.Now()
+	// A malformed response is kept as it was received, and the client is sent a
+	// 502 Bad Gateway instead.
+	if err != nil {
+		ups.discard(httpRequest)
 
-	// Read the server response and send it back to the client connection.
-	serverResponse = buffer.NewBuffer()
-	if err = serverResponse.Recvall(proxyConn); err != nil {
-		return err
+		dbdata.State = requests.StateParseError
+		dbdata.Err = fmt.Errorf("malformed response: %w", err)
+
+		if err = proxy.commit(&dbdata); err != nil {
+			log.Println(err)
+		}
+
+		return false, errorResponse(http.StatusBadGateway, dbdata.Err).Send(conn)
 	}
 
-	dbdata.RawResponse = serverResponse
-	dbdata.ResponseTime = time.Now()
-	dbdata.Elapsed = dbdata.ResponseTime.Sub(timer)
-	dbdata.Response = readResponse(httpRequest, serverResponse)
+	// The server closes the connection after responses without a length.
+	if dbdata.Response.Close {
+		ups.discard(httpRequest)
+	}
 
-	// Stall responses
-	if opts.InterceptServer && opts.Stall {
-		serverResponse, err = proxy.stall(serverResponse, &dbdata.IsResponseEdited)
+	// Stall responses matching the intercept rules
+	if opts := proxy.options(); opts.InterceptServer && opts.Stall && !dbdata.Passthrough &&
+		proxy.ruleset().interceptResponse(httpRequest, dbdata.Response) {
+		serverResponse, err = proxy.stall(
+			serverResponse,
+			DirectionResponse,
+			httpRequest,
+			&dbdata.IsResponseEdited,
+		)
 		if err != nil {
-			if err != ErrDropped {
-				return err
+			var dropped *dropError
+
+			if !errors.As(err, &dropped) {
+				return false, err
 			}
 
-			return nil
+			return false, proxy.drop(conn, &dbdata, DirectionResponse, dropped.cmd)
+		}
+
+		// The edited response is recorded as forwarded, along with the original.
+		if dbdata.IsResponseEdited {
+			dbdata.OriginalResponse = dbdata.RawResponse
+			dbdata.RawResponse = serverResponse
+
+			if res, err := readResponse(httpRequest, serverResponse); err == nil {
+				dbdata.Response = res
+			}
 		}
 	}
 
+	// A failed history write doesn't keep the response from the client.
 	if err = proxy.commit(&dbdata); err != nil {
-		return nil
+		log.Println(err)
+	}
+
+	if err = serverResponse.Send(conn); err != nil {
+		return false, err
+	}
+
+	// Streams multiplexed over HTTP/2 have no reader, and can't switch protocols.
+	if dbdata.Response.StatusCode == http.StatusSwitchingProtocols && reader != nil {
+		return false, proxy.upgrade(conn, reader, ups, &dbdata)
 	}
 
-	return serverResponse.Send(conn)
+	return !httpRequest.Close && !dbdata.Response.Close, nil
 }
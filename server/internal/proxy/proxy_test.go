@@ -0,0 +1,467 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ihaxolotl/webproxy/internal/data"
+	"github.com/ihaxolotl/webproxy/internal/data/history"
+	"github.com/ihaxolotl/webproxy/internal/data/projects"
+	"github.com/ihaxolotl/webproxy/internal/data/replace"
+	"github.com/ihaxolotl/webproxy/internal/data/settings"
+)
+
+// testDatabase returns a database with a single project, which is removed
+// when the test finishes.
+func testDatabase(t *testing.T) (*data.Database, string) {
+	db := data.New()
+	if err := db.SetupAt(filepath.Join(t.TempDir(), "db.sqlite")); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &projects.Project{Title: "Test Project", Description: "Proxy tests"}
+	if err := db.Projects.InsertAndFetch(p); err != nil {
+		t.Fatal(err)
+	}
+
+	return db, p.ID
+}
+
+func TestTunnelRoute(t *testing.T) {
+	tests := []struct {
+		tunnel   tunnel
+		host     string
+		expected string
+		err      error
+	}{
+		{tunnel{"https", "example.com:8443", ""}, "other.com", "https://example.com:8443/", nil},
+		{tunnel{"http", "", ""}, "example.com", "http://example.com/", nil},
+		{tunnel{"https", "", ""}, "example.com:8443", "https://example.com:8443/", nil},
+		{tunnel{"http", "", ""}, "", "", ErrNoHost},
+		{tunnel{"http", "127.0.0.1:8080", "example.com"}, "", "http://127.0.0.1:8080/", nil},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = test.host
+
+		if err = test.tunnel.route(req); err != test.err {
+			t.Fatalf("fatal: %+v: %v expected, %v returned.\n", test.tunnel, test.err, err)
+		}
+
+		if err == nil && req.URL.String() != test.expected {
+			t.Fatalf("fatal: %+v: %q expected, %q returned.\n", test.tunnel, test.expected, req.URL)
+		}
+
+		if test.tunnel.hostHeader != "" && req.Host != test.tunnel.hostHeader {
+			t.Fatalf("fatal: %+v: host %q expected, %q returned.\n", test.tunnel, test.tunnel.hostHeader, req.Host)
+		}
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		target   string
+		expected string
+		err      error
+	}{
+		{"https://example.com", "https://example.com", nil},
+		{"http://127.0.0.1:8080/", "http://127.0.0.1:8080", nil},
+		{"ftp://example.com", "", ErrTarget},
+		{"https://example.com/admin", "", ErrTarget},
+		{"https://user@example.com", "", ErrTarget},
+		{"example.com:443", "", ErrTarget},
+		{"", "", ErrTarget},
+	}
+
+	for _, test := range tests {
+		u, err := ParseTarget(test.target)
+		if err != test.err {
+			t.Fatalf("fatal: %q: %v expected, %v returned.\n", test.target, test.err, err)
+		}
+
+		if err == nil && u.String() != test.expected {
+			t.Fatalf("fatal: %q: %q expected, %q returned.\n", test.target, test.expected, u)
+		}
+	}
+}
+
+func TestReloadKeepsStall(t *testing.T) {
+	db, projectId := testDatabase(t)
+	prox := New(projectId, db)
+
+	if err := prox.Command(nil, ProxyCmd{Type: ProxyCmdStart}); err != nil {
+		t.Fatal(err)
+	}
+	item := prox.queue.push(testQueueData("GET / HTTP/1.1\r\n\r\n"), DirectionRequest, "localhost", "")
+
+	// Rule changes reload the proxy, which must not turn interception off.
+	rule := &replace.Rule{
+		ProjectID: projectId,
+		Enabled:   true,
+		Direction: replace.DirectionRequest,
+		Location:  replace.LocationHeaders,
+		Match:     "User-Agent: curl",
+		Replace:   "User-Agent: webproxy",
+	}
+	if err := db.Replace.Insert(rule); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := prox.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !prox.options().Stall {
+		t.Fatal("fatal: interception was turned off by a reload.")
+	}
+
+	select {
+	case cmd := <-item.reply:
+		t.Fatalf("fatal: stalled item was released with %s.\n", cmd.Type)
+	default:
+	}
+
+	// Turning interception off forwards the stalled items.
+	if err := prox.Command(nil, ProxyCmd{Type: ProxyCmdStop}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case cmd := <-item.reply:
+		if cmd.Type != ProxyCmdForward {
+			t.Fatalf("fatal: %s expected, %s received.\n", ProxyCmdForward, cmd.Type)
+		}
+	default:
+		t.Fatal("fatal: stalled item was not released.")
+	}
+
+	if err := prox.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if prox.options().Stall {
+		t.Fatal("fatal: interception was turned on by a reload.")
+	}
+}
+
+// testProxy returns a proxy backed by a test database.
+func testProxy(t *testing.T) *Proxy {
+	db, projectId := testDatabase(t)
+	prox := New(projectId, db)
+
+	if err := prox.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	return prox
+}
+
+// testServe serves a client connection with a proxy. It returns the client end
+// of the connection, and a channel receiving the result of serving it.
+func testServe(t *testing.T, prox *Proxy) (net.Conn, <-chan error) {
+	client, conn := net.Pipe()
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	t.Cleanup(func() { client.Close() })
+
+	done := make(chan error, 1)
+	go func() {
+		defer conn.Close()
+		done <- prox.serve(conn, nil)
+	}()
+
+	return client, done
+}
+
+// testReadResponse reads a response and its body from the client end of a
+// connection served by the proxy.
+func testReadResponse(t *testing.T, r *bufio.Reader) string {
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("fatal: %d expected, %d returned.\n", http.StatusOK, res.StatusCode)
+	}
+
+	return string(body)
+}
+
+// testHistory returns the number of exchanges recorded by a proxy.
+func testHistory(t *testing.T, prox *Proxy) int {
+	entries, err := prox.db.History.Fetch(prox.projectId, history.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(entries)
+}
+
+// testServer starts a target server answering with the request path, and
+// counts the connections made to it.
+func testServer(t *testing.T) (*httptest.Server, *int32) {
+	var dials int32
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.WriteString(rw, r.URL.Path)
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&dials, 1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return server, &dials
+}
+
+func TestServeKeepAlive(t *testing.T) {
+	server, dials := testServer(t)
+	prox := testProxy(t)
+	client, done := testServe(t, prox)
+	r := bufio.NewReader(client)
+
+	for _, path := range []string{"/one", "/two"} {
+		if _, err := io.WriteString(client, "GET "+server.URL+path+" HTTP/1.1\r\nHost: "+server.Listener.Addr().String()+"\r\n\r\n"); err != nil {
+			t.Fatal(err)
+		}
+
+		if body := testReadResponse(t, r); body != path {
+			t.Fatalf("fatal: %q expected, %q returned.\n", path, body)
+		}
+	}
+
+	client.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if n := testHistory(t, prox); n != 2 {
+		t.Fatalf("fatal: 2 history entries expected, %d returned.\n", n)
+	}
+
+	if n := atomic.LoadInt32(dials); n != 1 {
+		t.Fatalf("fatal: 1 upstream connection expected, %d made.\n", n)
+	}
+}
+
+func TestServePipelined(t *testing.T) {
+	server, dials := testServer(t)
+	prox := testProxy(t)
+	client, done := testServe(t, prox)
+	r := bufio.NewReader(client)
+
+	host := server.Listener.Addr().String()
+	requests := "GET " + server.URL + "/one HTTP/1.1\r\nHost: " + host + "\r\n\r\n" +
+		"GET " + server.URL + "/two HTTP/1.1\r\nHost: " + host + "\r\nConnection: close\r\n\r\n"
+
+	// Both requests are sent before either response is read.
+	go io.WriteString(client, requests)
+
+	for _, path := range []string{"/one", "/two"} {
+		if body := testReadResponse(t, r); body != path {
+			t.Fatalf("fatal: %q expected, %q returned.\n", path, body)
+		}
+	}
+
+	// The connection is closed by the proxy after a request asking for it.
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Fatalf("fatal: %v expected, %v returned.\n", io.EOF, err)
+	}
+
+	if n := testHistory(t, prox); n != 2 {
+		t.Fatalf("fatal: 2 history entries expected, %d returned.\n", n)
+	}
+
+	if n := atomic.LoadInt32(dials); n != 1 {
+		t.Fatalf("fatal: 1 upstream connection expected, %d made.\n", n)
+	}
+}
+
+func TestServeStaleUpstream(t *testing.T) {
+	var dials int32
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	// The target server closes every connection after one response, so the
+	// connection kept for the second request is stale.
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&dials, 1)
+
+			if _, err = http.ReadRequest(bufio.NewReader(conn)); err == nil {
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+			}
+			conn.Close()
+		}
+	}()
+
+	prox := testProxy(t)
+	client, done := testServe(t, prox)
+	r := bufio.NewReader(client)
+	target := "http://" + listener.Addr().String()
+
+	for i := 0; i < 2; i++ {
+		if _, err := io.WriteString(client, "GET "+target+"/ HTTP/1.1\r\nHost: "+listener.Addr().String()+"\r\n\r\n"); err != nil {
+			t.Fatal(err)
+		}
+
+		if body := testReadResponse(t, r); body != "ok" {
+			t.Fatalf("fatal: %q expected, %q returned.\n", "ok", body)
+		}
+	}
+
+	client.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if n := testHistory(t, prox); n != 2 {
+		t.Fatalf("fatal: 2 history entries expected, %d returned.\n", n)
+	}
+
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Fatalf("fatal: 2 upstream connections expected, %d made.\n", n)
+	}
+}
+
+// testPanel attaches a control panel to a proxy, and returns the control
+// panel's end of its WebSocket connection.
+func testPanel(t *testing.T, prox *Proxy) *websocket.Conn {
+	var upgrader websocket.Upgrader
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		c := prox.Attach(conn)
+		defer prox.Detach(c)
+
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	panel, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	panel.SetReadDeadline(time.Now().Add(10 * time.Second))
+	t.Cleanup(func() { panel.Close() })
+
+	return panel
+}
+
+func TestStallConcurrentConnections(t *testing.T) {
+	server, _ := testServer(t)
+	prox := testProxy(t)
+
+	// Only requests are intercepted.
+	s := settings.Default(prox.projectId)
+	s.InterceptServer = false
+	if err := prox.db.Settings.Save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := prox.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := prox.Command(nil, ProxyCmd{Type: ProxyCmdStart}); err != nil {
+		t.Fatal(err)
+	}
+	panel := testPanel(t, prox)
+
+	paths := []string{"/one", "/two"}
+	readers := make(map[string]*bufio.Reader)
+
+	for _, path := range paths {
+		client, _ := testServe(t, prox)
+		readers[path] = bufio.NewReader(client)
+
+		go io.WriteString(client, "GET "+server.URL+path+" HTTP/1.1\r\nHost: "+server.Listener.Addr().String()+"\r\n\r\n")
+	}
+
+	// Both requests are stalled at once.
+	stalled := make(map[string]ProxyCmd)
+
+	for len(stalled) < len(paths) {
+		var cmd ProxyCmd
+
+		if err := panel.ReadJSON(&cmd); err != nil {
+			t.Fatal(err)
+		}
+
+		if cmd.Type != ProxyCmdStall {
+			t.Fatalf("fatal: %s expected, %s received.\n", ProxyCmdStall, cmd.Type)
+		}
+
+		for _, path := range paths {
+			if strings.HasPrefix(cmd.Data, "GET "+path+" ") {
+				stalled[path] = cmd
+			}
+		}
+	}
+
+	if n := prox.queue.len(); n != len(paths) {
+		t.Fatalf("fatal: %d stalled items expected, %d pending.\n", len(paths), n)
+	}
+
+	// The requests are forwarded by ID, in the opposite order they were
+	// stalled in.
+	for i := len(paths) - 1; i >= 0; i-- {
+		path := paths[i]
+		cmd := stalled[path]
+
+		forward := ProxyCmd{Type: ProxyCmdForward, ID: cmd.ID, Data: cmd.Data, Encoding: cmd.Encoding}
+		if err := prox.Command(nil, forward); err != nil {
+			t.Fatal(err)
+		}
+
+		if body := testReadResponse(t, readers[path]); body != path {
+			t.Fatalf("fatal: %q expected, %q returned.\n", path, body)
+		}
+
+		if n := prox.queue.len(); n != i {
+			t.Fatalf("fatal: %d stalled items expected, %d pending.\n", i, n)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ihaxolotl/webproxy/internal/buffer"
+)
+
+// interception is a request or response stalled at the control panel. The
+// connection that stalled it blocks until a command is sent on reply.
+type interception struct {
+	id        string         // Unique ID of the interception
+	direction Direction      // Direction of the stalled data
+	host      string         // Target host of the stalled data
+	opcode    string         // Opcode of a stalled WebSocket frame, empty for HTTP messages
+	timestamp time.Time      // Time the data was stalled
+	data      *buffer.Buffer // Stalled data, including edits
+	reply     chan ProxyCmd  // Forward or drop command for the stalled data
+}
+
+// cmd returns the command describing the interception to the control panel.
+func (item *interception) cmd() ProxyCmd {
+	cmd := ProxyCmd{
+		Type:      ProxyCmdStall,
+		ID:        item.id,
+		Direction: item.direction,
+		Host:      item.host,
+		Opcode:    item.opcode,
+		Timestamp: &item.timestamp,
+	}
+	cmd.Data, cmd.Encoding = EncodePayload(item.data.Buffer())
+
+	return cmd
+}
+
+// queue holds the interceptions waiting for a command from the control panel.
+// Connections are handled concurrently, so several interceptions may be pending
+// at once. Commands are applied to the interception they refer to by ID, or to
+// the interception that was stalled first.
+type queue struct {
+	mu    sync.Mutex
+	items []*interception
+}
+
+// push adds a new interception for stalled data to the back of the queue. The
+// opcode is only set for WebSocket frames.
+func (q *queue) push(data *buffer.Buffer, direction Direction, host string, opcode string) *interception {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item := &interception{
+		id:        uuid.New().String(),
+		direction: direction,
+		host:      host,
+		opcode:    opcode,
+		timestamp: time.Now(),
+		data:      data,
+		reply:     make(chan ProxyCmd, 1),
+	}
+	q.items = append(q.items, item)
+
+	return item
+}
+
+// find returns the index of the interception with an ID, or the first
+// interception if the ID is empty. It returns -1 if there is no such
+// interception. The caller must hold the lock.
+func (q *queue) find(id string) int {
+	for i, item := range q.items {
+		if id == "" || item.id == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// has reports whether an interception with an ID is pending.
+func (q *queue) has(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.find(id) >= 0
+}
+
+// remove removes an interception from the queue without replying to it.
+func (q *queue) remove(item *interception) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, v := range q.items {
+		if v == item {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return
+		}
+	}
+}
+
+// pop removes the interception a command refers to from the queue and sends it
+// the command. It returns false if there is no such interception.
+func (q *queue) pop(cmd ProxyCmd) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.find(cmd.ID)
+	if i < 0 {
+		return false
+	}
+
+	item := q.items[i]
+	q.items = append(q.items[:i], q.items[i+1:]...)
+	item.reply <- cmd
+
+	return true
+}
+
+// edit replaces the data of a pending interception without forwarding it.
+// It returns false if there is no such interception.
+func (q *queue) edit(id string, data []byte) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.find(id)
+	if i < 0 {
+		return false
+	}
+
+	q.items[i].data = buffer.NewBufferFrom(data, len(data))
+
+	return true
+}
+
+// list returns the commands describing every pending interception, in the
+// order they were stalled.
+func (q *queue) list() []ProxyCmd {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := make([]ProxyCmd, 0, len(q.items))
+	for _, item := range q.items {
+		items = append(items, item.cmd())
+	}
+
+	return items
+}
+
+// len returns the number of pending interceptions.
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.items)
+}
+
+// release forwards every pending interception as it currently is.
+func (q *queue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, item := range q.items {
+		cmd := ProxyCmd{Type: ProxyCmdForward, ID: item.id}
+		cmd.Data, cmd.Encoding = EncodePayload(item.data.Buffer())
+
+		item.reply <- cmd
+	}
+	q.items = nil
+}
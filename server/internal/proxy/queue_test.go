@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/ihaxolotl/webproxy/internal/buffer"
+)
+
+func testQueueData(s string) *buffer.Buffer {
+	return buffer.NewBufferFrom([]byte(s), len(s))
+}
+
+func TestQueuePop(t *testing.T) {
+	var q queue
+
+	first := q.push(testQueueData("first"), DirectionRequest, "localhost", "")
+	second := q.push(testQueueData("second"), DirectionResponse, "localhost", "")
+
+	// Commands with an ID apply to that item, regardless of order.
+	if !q.pop(ProxyCmd{Type: ProxyCmdDrop, ID: second.id}) {
+		t.Fatal("fatal: item was not found by ID.")
+	}
+
+	if cmd := <-second.reply; cmd.Type != ProxyCmdDrop {
+		t.Fatalf("fatal: %s expected, %s received.\n", ProxyCmdDrop, cmd.Type)
+	}
+
+	// Commands without an ID apply to the first item.
+	if !q.pop(ProxyCmd{Type: ProxyCmdForward, Data: "first"}) {
+		t.Fatal("fatal: first item was not found.")
+	}
+
+	if cmd := <-first.reply; cmd.Type != ProxyCmdForward {
+		t.Fatalf("fatal: %s expected, %s received.\n", ProxyCmdForward, cmd.Type)
+	}
+
+	if q.pop(ProxyCmd{Type: ProxyCmdForward, ID: first.id}) {
+		t.Fatal("fatal: forwarded item is still pending.")
+	}
+}
+
+func TestQueueEdit(t *testing.T) {
+	var q queue
+
+	item := q.push(testQueueData("original"), DirectionRequest, "localhost", "")
+
+	if !q.edit(item.id, []byte("edited")) {
+		t.Fatal("fatal: item was not found by ID.")
+	}
+
+	items := q.list()
+	if len(items) != 1 || items[0].Data != "edited" {
+		t.Fatalf("fatal: unexpected queue: %+v\n", items)
+	}
+
+	q.release()
+
+	if cmd := <-item.reply; cmd.Data != "edited" {
+		t.Fatalf("fatal: released item was not edited: %+v\n", cmd)
+	}
+}
+
+func TestQueueBinaryData(t *testing.T) {
+	var q queue
+
+	data := "POST / HTTP/1.1\r\nContent-Length: 3\r\n\r\n\x00\xff\xfe"
+	item := q.push(testQueueData(data), DirectionRequest, "localhost", "")
+
+	cmd := item.cmd()
+	if cmd.Encoding != EncodingBase64 {
+		t.Fatalf("fatal: %s encoding expected, %s returned.\n", EncodingBase64, cmd.Encoding)
+	}
+
+	// The control panel forwards the payload in the encoding it received.
+	cmd.Type = ProxyCmdForward
+	if err := cmd.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := cmd.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(payload) != data {
+		t.Fatalf("fatal: %q expected, %q decoded.\n", data, payload)
+	}
+
+	cmd.Data = "not base64!"
+	if err = cmd.Validate(); err != ErrInvalidEncoding {
+		t.Fatalf("fatal: invalid payload passed validation: %v\n", err)
+	}
+}
@@ -3,68 +3,241 @@ package proxy
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"net/http"
+	"regexp"
 	"strings"
 
 	"github.com/ihaxolotl/webproxy/internal/buffer"
+	"github.com/ihaxolotl/webproxy/internal/data/replace"
 )
 
+// filter rewrites one part of an HTTP message. Filters are either built into the
+// proxy or compiled from the project's match/replace rules.
 type filter struct {
-	Find    string
-	Replace string
+	id       string         // Unique ID of the match/replace rule, empty if built in
+	location string         // Part of the message that is rewritten
+	find     []byte         // Literal text to replace
+	pattern  *regexp.Regexp // Pattern to replace, used instead of find if set
+	replace  []byte         // Replacement for the matched text
+}
+
+// compileFilter creates a filter from a match/replace rule.
+func compileFilter(r replace.Rule) (f filter, err error) {
+	f = filter{
+		id:       r.ID,
+		location: r.Location,
+		find:     []byte(r.Match),
+		replace:  []byte(r.Replace),
+	}
+
+	if r.Regex {
+		f.pattern, err = regexp.Compile(r.Match)
+	}
+
+	return f, err
+}
+
+// apply replaces every match of the filter in a part of a message. It reports
+// whether anything matched.
+func (f *filter) apply(part []byte) ([]byte, bool) {
+	if f.pattern != nil {
+		if !f.pattern.Match(part) {
+			return part, false
+		}
+
+		return f.pattern.ReplaceAll(part, f.replace), true
+	}
+
+	if !bytes.Contains(part, f.find) {
+		return part, false
+	}
+
+	return bytes.ReplaceAll(part, f.find, f.replace), true
+}
+
+// splitMessage splits a raw HTTP message into its start line, its header fields,
+// the empty line ending the header section and its body. The start line and the
+// header fields keep their line endings.
+func splitMessage(raw []byte) (start, headers, sep, body []byte) {
+	i := bytes.IndexByte(raw, '\n')
+	if i < 0 {
+		return raw, nil, nil, nil
+	}
+
+	start, headers = raw[:i+1], raw[i+1:]
+
+	for off := 0; off < len(headers); {
+		j := bytes.IndexByte(headers[off:], '\n')
+		if j < 0 {
+			break
+		}
+
+		line := headers[off : off+j+1]
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			return start, headers[:off], line, headers[off+j+1:]
+		}
+
+		off += j + 1
+	}
+
+	return start, headers, nil, nil
+}
+
+// filterMessage applies filters to a raw HTTP message in order. It returns the
+// rewritten message and the IDs of the match/replace rules that matched. If the
+// body was rewritten, the framing of the message is fixed to match it, as it is
+// for edited messages. Head reports whether the message is a response to a HEAD
+// request.
+func filterMessage(raw []byte, filters []filter, head bool) ([]byte, []string) {
+	var (
+		parts [4][]byte
+		body  []byte
+		hits  []string
+		dst   []byte
+	)
+
+	parts[0], parts[1], parts[2], parts[3] = splitMessage(raw)
+	body = parts[3]
+
+	for i := range filters {
+		var (
+			f       = &filters[i]
+			part    *[]byte
+			matched bool
+		)
+
+		switch f.location {
+		case replace.LocationStartLine:
+			part = &parts[0]
+		case replace.LocationHeaders:
+			part = &parts[1]
+		case replace.LocationBody:
+			part = &parts[3]
+		default:
+			continue
+		}
+
+		if *part, matched = f.apply(*part); matched && f.id != "" {
+			hits = append(hits, f.id)
+		}
+	}
+
+	dst = make([]byte, 0, len(raw))
+	for _, part := range parts {
+		dst = append(dst, part...)
+	}
+
+	if !bytes.Equal(parts[3], body) {
+		dst = fixFraming(dst, head)
+	}
+
+	return dst, hits
 }
 
 // parseProxyRequest parses an HTTP request crafted for a proxy and creates a new request
 // that can be processed by the target web server.
 func parseProxyRequest(src *buffer.Buffer, req *http.Request) (*buffer.Buffer, error) {
-	var (
-		dst  []byte
-		n    int
-		diff int
-	)
+	var dst []byte
 
-	// HACK: Replace the proxy headers in the request.
+	// Replace the proxy headers in the request.
 	filters := []filter{
-		{Find: "Proxy-Connection:", Replace: "Connection:"},
-		{Find: req.URL.Scheme + "://" + req.URL.Host, Replace: ""},
+		{
+			location: replace.LocationHeaders,
+			pattern:  regexp.MustCompile(`(?im)^Proxy-Connection:`),
+			replace:  []byte("Connection:"),
+		},
 	}
 
-	dst = make([]byte, buffer.ReadBufferSize)
-	n = src.Size()
+	// Requests in absolute-form are rewritten to origin-form, where an empty
+	// path is sent as "/". Requests tunneled through CONNECT are already in
+	// origin-form.
+	if req.URL.IsAbs() {
+		origin := "${1}"
+		if req.URL.Path == "" {
+			origin = "${1}/"
+		}
+
+		filters = append(filters, filter{
+			location: replace.LocationStartLine,
+			pattern:  regexp.MustCompile(`^(?i)(\S+ )` + regexp.QuoteMeta(req.URL.Scheme+"://"+req.URL.Host)),
+			replace:  []byte(origin),
+		})
+	}
 
-	copy(dst, src.Buffer())
+	// WebSocket extensions are not negotiated, so that the frames relayed over
+	// the upgraded connection can be read and edited as they are.
+	if isWebSocket(req.Header) {
+		filters = append(filters, filter{
+			location: replace.LocationHeaders,
+			pattern:  regexp.MustCompile(`(?im)^Sec-WebSocket-Extensions:[^\n]*\n`),
+		})
+	}
 
-	for _, v := range filters {
-		if strings.Contains(string(dst), v.Find) {
-			dst = []byte(strings.Replace(string(dst), v.Find, v.Replace, 1))
+	dst, _ = filterMessage(src.Buffer(), filters, false)
 
-			diff = len(v.Find) - len(v.Replace)
-			if n >= diff {
-				n -= diff
-			}
-		}
+	return buffer.NewBufferFrom(dst, len(dst)), nil
+}
+
+// rewriteHost replaces the value of the Host header of a request. A request
+// without a Host header is left as it is.
+func rewriteHost(src *buffer.Buffer, host string) *buffer.Buffer {
+	filters := []filter{
+		{
+			location: replace.LocationHeaders,
+			pattern:  regexp.MustCompile(`(?im)^Host:[^\r\n]*`),
+			replace:  []byte("Host: " + strings.ReplaceAll(host, "$", "$$")),
+		},
 	}
 
-	return buffer.NewBufferFrom(dst, n), nil
+	dst, _ := filterMessage(src.Buffer(), filters, false)
+
+	return buffer.NewBufferFrom(dst, len(dst))
 }
 
 // readRequest parses an http.Request object from a byte slice.
-func readRequest(buf *buffer.Buffer) *http.Request {
-	// HACK: Parse the the request to get the hostname.
-	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf.Buffer())))
+func readRequest(buf *buffer.Buffer) (*http.Request, error) {
+	return http.ReadRequest(bufio.NewReader(bytes.NewReader(buf.Buffer())))
+}
+
+// reparseRequest parses a request that was edited at the control panel. The edited
+// request keeps the target of the request it was stalled as, since the target
+// server is dialed from it, whatever the edited start line and Host header say.
+func reparseRequest(buf *buffer.Buffer, target *http.Request) (*http.Request, error) {
+	req, err := readRequest(buf)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return req
+	req.URL.Scheme = target.URL.Scheme
+	req.URL.Host = target.URL.Host
+
+	return req, nil
 }
 
 // readResponse parses an http.Response object from a byte slice.
-func readResponse(req *http.Request, buf *buffer.Buffer) *http.Response {
-	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf.Buffer())), req)
-	if err != nil {
-		panic(err)
-	}
+func readResponse(req *http.Request, buf *buffer.Buffer) (*http.Response, error) {
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(buf.Buffer())), req)
+}
+
+// errorResponse creates a response generated by the proxy to report an error
+// to the client.
+func errorResponse(status int, err error) *buffer.Buffer {
+	var (
+		body string
+		raw  string
+	)
+
+	body = fmt.Sprintf("%d %s\n\n%s\n", status, http.StatusText(status), err)
+	raw = fmt.Sprintf(
+		"HTTP/1.1 %d %s\r\n"+
+			"Content-Type: text/plain; charset=utf-8\r\n"+
+			"Content-Length: %d\r\n"+
+			"Connection: close\r\n"+
+			"\r\n"+
+			"%s",
+		status, http.StatusText(status), len(body), body,
+	)
 
-	return res
+	return buffer.NewBufferFrom([]byte(raw), len(raw))
 }
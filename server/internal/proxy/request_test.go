@@ -0,0 +1,261 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/ihaxolotl/webproxy/internal/buffer"
+	"github.com/ihaxolotl/webproxy/internal/data/replace"
+)
+
+var testFuzzRequests = []string{
+	"GET http://localhost/ HTTP/1.1\r\nHost: localhost\r\nProxy-Connection: keep-alive\r\n\r\n",
+	"POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+	"CONNECT localhost:443 HTTP/1.1\r\nHost: localhost:443\r\n\r\n",
+	"\x16\x03\x01\x00\xa5\x01\x00\x00\xa1\x03\x03",
+}
+
+var testFuzzResponses = []string{
+	"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello",
+	"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+	"HTTP/1.0 200 OK\r\n\r\nclose-delimited",
+	"SSH-2.0-OpenSSH_8.9\r\n",
+}
+
+// FuzzReadRequest checks that reading and parsing a request from a client never
+// panics, whatever bytes the client sends.
+func FuzzReadRequest(f *testing.F) {
+	for _, raw := range testFuzzRequests {
+		f.Add([]byte(raw))
+	}
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		buf, err := buffer.NewReader(bytes.NewReader(raw)).ReadRequest()
+		if err != nil {
+			return
+		}
+
+		req, err := readRequest(buf)
+		if err != nil {
+			return
+		}
+
+		if _, err = parseProxyRequest(buf, req); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// FuzzReadResponse checks that reading and parsing a response from a server never
+// panics, whatever bytes the server sends.
+func FuzzReadResponse(f *testing.F) {
+	for _, raw := range testFuzzResponses {
+		f.Add([]byte(raw), http.MethodGet)
+	}
+
+	f.Fuzz(func(t *testing.T, raw []byte, method string) {
+		req, err := http.NewRequest(method, "http://localhost/", nil)
+		if err != nil {
+			return
+		}
+
+		buf, err := buffer.NewReader(bytes.NewReader(raw)).ReadResponse(req.Method)
+		if err != nil {
+			return
+		}
+
+		readResponse(req, buf)
+	})
+}
+
+func TestErrorResponse(t *testing.T) {
+	buf := errorResponse(http.StatusBadGateway, http.ErrNotSupported)
+
+	res, err := readResponse(nil, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != http.StatusBadGateway {
+		t.Fatalf("fatal: status %d expected, %d returned.\n", http.StatusBadGateway, res.StatusCode)
+	}
+}
+
+func TestParseProxyRequest(t *testing.T) {
+	raw := []byte("GET http://localhost/?next=http://localhost/ HTTP/1.1\r\nHost: localhost\r\nProxy-Connection: keep-alive\r\n\r\n")
+	expected := "GET /?next=http://localhost/ HTTP/1.1\r\nHost: localhost\r\nConnection: keep-alive\r\n\r\n"
+
+	req, err := readRequest(buffer.NewBufferFrom(raw, len(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := parseProxyRequest(buffer.NewBufferFrom(raw, len(raw)), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf.Buffer()) != expected {
+		t.Fatalf("fatal: %q expected, %q returned.\n", expected, buf.Buffer())
+	}
+}
+
+func TestParseProxyRequestEmptyPath(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected string
+	}{
+		{"GET http://localhost HTTP/1.1\r\nHost: localhost\r\n\r\n", "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"},
+		{"GET http://localhost:8080?q=1 HTTP/1.1\r\nHost: localhost\r\n\r\n", "GET /?q=1 HTTP/1.1\r\nHost: localhost\r\n\r\n"},
+	}
+
+	for _, test := range tests {
+		raw := []byte(test.raw)
+
+		req, err := readRequest(buffer.NewBufferFrom(raw, len(raw)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf, err := parseProxyRequest(buffer.NewBufferFrom(raw, len(raw)), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(buf.Buffer()) != test.expected {
+			t.Fatalf("fatal: %q expected, %q returned.\n", test.expected, buf.Buffer())
+		}
+	}
+}
+
+func TestRewriteHost(t *testing.T) {
+	raw := []byte("GET / HTTP/1.1\r\nhost: localhost:8080\r\nX-Host: localhost\r\n\r\nHost: body")
+	expected := "GET / HTTP/1.1\r\nHost: example.com\r\nX-Host: localhost\r\n\r\nHost: body"
+
+	buf := rewriteHost(buffer.NewBufferFrom(raw, len(raw)), "example.com")
+
+	if string(buf.Buffer()) != expected {
+		t.Fatalf("fatal: %q expected, %q returned.\n", expected, buf.Buffer())
+	}
+}
+
+func TestFilterMessage(t *testing.T) {
+	raw := []byte("HTTP/1.1 200 OK\r\nContent-Security-Policy: default-src 'self'\r\nContent-Length: 5\r\n\r\nOK OK")
+	expected := "HTTP/1.1 200 Fine\r\nContent-Length: 5\r\nX-Test: 1\r\n\r\nKO KO"
+
+	rules := []replace.Rule{
+		{ID: "status", Location: replace.LocationStartLine, Match: "OK", Replace: "Fine"},
+		{ID: "csp", Location: replace.LocationHeaders, Regex: true, Match: `(?im)^Content-Security-Policy:.*\r\n`},
+		{ID: "inject", Location: replace.LocationHeaders, Regex: true, Match: `$`, Replace: "X-Test: 1\r\n"},
+		{ID: "body", Location: replace.LocationBody, Match: "OK", Replace: "KO"},
+		{ID: "miss", Location: replace.LocationBody, Match: "absent"},
+	}
+
+	filters := make([]filter, 0, len(rules))
+	for _, r := range rules {
+		f, err := compileFilter(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filters = append(filters, f)
+	}
+
+	dst, hits := filterMessage(raw, filters, false)
+	if string(dst) != expected {
+		t.Fatalf("fatal: %q expected, %q returned.\n", expected, dst)
+	}
+
+	if len(hits) != 4 || hits[3] != "body" {
+		t.Fatalf("fatal: unexpected hits %v\n", hits)
+	}
+}
+
+func TestFilterMessageFraming(t *testing.T) {
+	tests := []struct {
+		raw      string
+		match    string
+		replace  string
+		head     bool
+		expected string
+	}{
+		{
+			"POST /login HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc",
+			"abc", "aaaaaaaaaa", false,
+			"POST /login HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\naaaaaaaaaa",
+		},
+		{
+			"HTTP/1.1 200 OK\r\nContent-Length: 11\r\n\r\nhello world",
+			" world", "", false,
+			"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello",
+		},
+		{
+			"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+			"hello", "goodbye", false,
+			"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n7\r\ngoodbye\r\n0\r\n\r\n",
+		},
+		{
+			"HTTP/1.1 200 OK\r\nContent-Length: 11\r\n\r\n",
+			"^$", "ignored", true,
+			"HTTP/1.1 200 OK\r\nContent-Length: 11\r\n\r\nignored",
+		},
+	}
+
+	for _, test := range tests {
+		f, err := compileFilter(replace.Rule{
+			ID:       "body",
+			Location: replace.LocationBody,
+			Regex:    true,
+			Match:    test.match,
+			Replace:  test.replace,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dst, _ := filterMessage([]byte(test.raw), []filter{f}, test.head)
+		if string(dst) != test.expected {
+			t.Fatalf("fatal: %q expected, %q returned.\n", test.expected, dst)
+		}
+	}
+}
+
+func TestReparseRequest(t *testing.T) {
+	raw := []byte("HEAD /edited HTTP/1.1\r\nHost: elsewhere.com\r\n\r\n")
+
+	target, err := http.NewRequest(http.MethodGet, "https://example.com:8443/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := reparseRequest(buffer.NewBufferFrom(raw, len(raw)), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Method != http.MethodHead || req.URL.String() != "https://example.com:8443/edited" {
+		t.Fatalf("fatal: %s %s returned for the edited request.\n", req.Method, req.URL)
+	}
+}
+
+func TestParseProxyRequestWebSocket(t *testing.T) {
+	raw := []byte("GET /chat HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Extensions: permessage-deflate\r\n\r\n")
+	expected := "GET /chat HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"
+
+	req, err := readRequest(buffer.NewBufferFrom(raw, len(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := parseProxyRequest(buffer.NewBufferFrom(raw, len(raw)), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf.Buffer()) != expected {
+		t.Fatalf("fatal: %q expected, %q returned.\n", expected, buf.Buffer())
+	}
+}
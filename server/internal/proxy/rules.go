@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ihaxolotl/webproxy/internal/data/chain"
+	"github.com/ihaxolotl/webproxy/internal/data/intercept"
+	"github.com/ihaxolotl/webproxy/internal/data/replace"
+	"github.com/ihaxolotl/webproxy/internal/data/scope"
+)
+
+// rule is an intercept rule with its pattern compiled.
+type rule struct {
+	intercept.Rule
+	pattern    *regexp.Regexp  // Pattern for fields matched with a regular expression
+	extensions map[string]bool // Extensions matched by extension rules
+}
+
+// ruleset is the compiled set of a project's enabled scope, intercept,
+// match/replace and upstream proxy rules.
+type ruleset struct {
+	request         []rule       // Rules deciding whether requests are intercepted
+	response        []rule       // Rules deciding whether responses are intercepted
+	requestFilters  []filter     // Filters rewriting requests
+	responseFilters []filter     // Filters rewriting responses
+	scope           *scope.Scope // Rules deciding whether targets are in scope
+	chain           []chainRule  // Rules picking the upstream proxy of targets
+}
+
+// loadRules fetches and compiles the rules of the proxy's project.
+func (proxy *Proxy) loadRules() (*ruleset, error) {
+	var (
+		set          *ruleset
+		rules        []intercept.Rule
+		replaceRules []replace.Rule
+		scopeRules   []scope.Rule
+		chainRules   []chain.Rule
+		err          error
+	)
+
+	if rules, err = proxy.db.Intercept.Fetch(proxy.projectId); err != nil {
+		return nil, err
+	}
+
+	if replaceRules, err = proxy.db.Replace.Fetch(proxy.projectId); err != nil {
+		return nil, err
+	}
+
+	if scopeRules, err = proxy.db.Scope.Fetch(proxy.projectId); err != nil {
+		return nil, err
+	}
+
+	if chainRules, err = proxy.db.Chain.Fetch(proxy.projectId); err != nil {
+		return nil, err
+	}
+
+	set = compileRules(rules, replaceRules)
+	set.scope = scope.Compile(scopeRules)
+	set.chain = compileChain(chainRules)
+
+	return set, nil
+}
+
+// compileRules compiles the enabled rules of a project. Rules are validated when
+// they are saved, so a rule that fails to compile is skipped.
+func compileRules(rules []intercept.Rule, replaceRules []replace.Rule) *ruleset {
+	set := &ruleset{}
+
+	for _, r := range replaceRules {
+		if !r.Enabled || r.Validate() != nil {
+			continue
+		}
+
+		f, err := compileFilter(r)
+		if err != nil {
+			continue
+		}
+
+		if r.Direction == replace.DirectionRequest {
+			set.requestFilters = append(set.requestFilters, f)
+		} else {
+			set.responseFilters = append(set.responseFilters, f)
+		}
+	}
+
+	for _, r := range rules {
+		var err error
+
+		if !r.Enabled || r.Validate() != nil {
+			continue
+		}
+
+		c := rule{Rule: r}
+
+		if r.Field == intercept.FieldExtension {
+			c.extensions = make(map[string]bool)
+
+			for _, ext := range strings.Split(r.Pattern, ",") {
+				ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+				c.extensions[ext] = true
+			}
+		} else if c.pattern, err = regexp.Compile(r.Pattern); err != nil {
+			continue
+		}
+
+		if r.Direction == intercept.DirectionRequest {
+			set.request = append(set.request, c)
+		} else {
+			set.response = append(set.response, c)
+		}
+	}
+
+	return set
+}
+
+// match reports whether the rule matches an exchange. The response is nil when
+// the request is being matched.
+func (r *rule) match(req *http.Request, res *http.Response) bool {
+	var matched bool
+
+	switch r.Field {
+	case intercept.FieldHost:
+		matched = r.pattern.MatchString(req.URL.Hostname())
+	case intercept.FieldMethod:
+		matched = r.pattern.MatchString(req.Method)
+	case intercept.FieldPath:
+		matched = r.pattern.MatchString(req.URL.Path)
+	case intercept.FieldExtension:
+		ext := strings.ToLower(strings.TrimPrefix(path.Ext(req.URL.Path), "."))
+		matched = r.extensions[ext]
+	case intercept.FieldHeader:
+		header := req.Header
+		if res != nil {
+			header = res.Header
+		}
+
+		for _, value := range header.Values(r.Header) {
+			if r.pattern.MatchString(value) {
+				matched = true
+				break
+			}
+		}
+	case intercept.FieldStatus:
+		matched = res != nil && r.pattern.MatchString(strconv.Itoa(res.StatusCode))
+	case intercept.FieldContentType:
+		header := req.Header
+		if res != nil {
+			header = res.Header
+		}
+
+		matched = r.pattern.MatchString(header.Get("Content-Type"))
+	}
+
+	return matched != r.Negate
+}
+
+// evaluate combines the results of the rules from left to right. Every message
+// is intercepted when there are no rules.
+func evaluate(rules []rule, req *http.Request, res *http.Response) bool {
+	var result bool
+
+	if len(rules) == 0 {
+		return true
+	}
+
+	for i := range rules {
+		r := &rules[i]
+
+		switch {
+		case i == 0:
+			result = r.match(req, res)
+		case r.Operator == intercept.OperatorOr:
+			result = result || r.match(req, res)
+		default:
+			result = result && r.match(req, res)
+		}
+	}
+
+	return result
+}
+
+// interceptRequest reports whether a request matches the request rules.
+func (set *ruleset) interceptRequest(req *http.Request) bool {
+	if set == nil {
+		return true
+	}
+
+	return evaluate(set.request, req, nil)
+}
+
+// interceptResponse reports whether a response matches the response rules.
+func (set *ruleset) interceptResponse(req *http.Request, res *http.Response) bool {
+	if set == nil {
+		return true
+	}
+
+	return evaluate(set.response, req, res)
+}
+
+// filters returns the filters rewriting the messages of a direction.
+func (set *ruleset) filters(direction Direction) []filter {
+	if set == nil {
+		return nil
+	}
+
+	if direction == DirectionRequest {
+		return set.requestFilters
+	}
+
+	return set.responseFilters
+}
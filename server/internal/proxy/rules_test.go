@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ihaxolotl/webproxy/internal/data/intercept"
+)
+
+func TestRulesetEvaluate(t *testing.T) {
+	set := compileRules([]intercept.Rule{
+		{Enabled: true, Direction: intercept.DirectionRequest, Operator: intercept.OperatorAnd, Field: intercept.FieldHost, Pattern: `example\.com$`},
+		{Enabled: true, Direction: intercept.DirectionRequest, Operator: intercept.OperatorAnd, Field: intercept.FieldExtension, Pattern: "js, .css", Negate: true},
+		{Enabled: true, Direction: intercept.DirectionRequest, Operator: intercept.OperatorOr, Field: intercept.FieldMethod, Pattern: "^POST$"},
+		{Enabled: false, Direction: intercept.DirectionRequest, Operator: intercept.OperatorAnd, Field: intercept.FieldPath, Pattern: "^/never$"},
+		{Enabled: true, Direction: intercept.DirectionResponse, Operator: intercept.OperatorAnd, Field: intercept.FieldStatus, Pattern: `^5\d\d$`},
+	}, nil)
+
+	requests := []struct {
+		method, url string
+		intercept   bool
+	}{
+		{"GET", "http://www.example.com/index.html", true},
+		{"GET", "http://www.example.com/app.JS", false},
+		{"GET", "http://other.org/", false},
+		{"POST", "http://other.org/style.css", true},
+	}
+
+	for _, tc := range requests {
+		req := httptest.NewRequest(tc.method, tc.url, nil)
+
+		if got := set.interceptRequest(req); got != tc.intercept {
+			t.Fatalf("fatal: %s %s: intercept %v expected, %v returned.\n", tc.method, tc.url, tc.intercept, got)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+
+	if set.interceptResponse(req, &http.Response{StatusCode: 200}) {
+		t.Fatalf("fatal: 200 response intercepted.\n")
+	}
+
+	if !set.interceptResponse(req, &http.Response{StatusCode: 503}) {
+		t.Fatalf("fatal: 503 response not intercepted.\n")
+	}
+
+	if !(*ruleset)(nil).interceptRequest(req) || !compileRules(nil, nil).interceptRequest(req) {
+		t.Fatalf("fatal: request not intercepted without rules.\n")
+	}
+}
@@ -0,0 +1,15 @@
+package proxy
+
+import (
+	"net/url"
+)
+
+// inScope reports whether a URL matches an include rule and no exclude rule.
+// Every URL is in scope when there are no include rules.
+func (set *ruleset) inScope(u *url.URL) bool {
+	if set == nil {
+		return true
+	}
+
+	return set.scope.Contains(u)
+}
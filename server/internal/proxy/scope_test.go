@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/ihaxolotl/webproxy/internal/data/scope"
+)
+
+func TestRulesetInScope(t *testing.T) {
+	set := &ruleset{scope: scope.Compile([]scope.Rule{
+		{Enabled: true, Action: scope.ActionInclude, Host: `(^|\.)example\.com$`, Regex: true},
+		{Enabled: true, Action: scope.ActionExclude, Scheme: "http", Port: 80, PathPrefix: "/static/"},
+		{Enabled: false, Action: scope.ActionExclude, Host: "www"},
+	})}
+
+	urls := []struct {
+		url     string
+		inScope bool
+	}{
+		{"https://www.example.com/static/app.js", true},
+		{"http://www.example.com/static/app.js", false},
+		{"http://example.com:8080/static/app.js", true},
+		{"https://example.org/", false},
+	}
+
+	for _, tc := range urls {
+		u, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := set.inScope(u); got != tc.inScope {
+			t.Fatalf("fatal: %s: in scope %v expected, %v returned.\n", tc.url, tc.inScope, got)
+		}
+	}
+
+	if u, _ := url.Parse("https://example.org/"); !(&ruleset{}).inScope(u) {
+		t.Fatalf("fatal: URL out of scope without include rules.\n")
+	}
+}
+
+func TestRulesetInScopeLiteralHost(t *testing.T) {
+	set := &ruleset{scope: scope.Compile([]scope.Rule{
+		{Enabled: true, Action: scope.ActionInclude, Host: "example.com"},
+	})}
+
+	urls := []struct {
+		url     string
+		inScope bool
+	}{
+		{"https://example.com/", true},
+		{"https://EXAMPLE.com:8443/", true},
+		{"https://example.com.evil.net/", false},
+		{"https://exampleXcom/", false},
+		{"https://www.example.com/", false},
+	}
+
+	for _, tc := range urls {
+		u, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := set.inScope(u); got != tc.inScope {
+			t.Fatalf("fatal: %s: in scope %v expected, %v returned.\n", tc.url, tc.inScope, got)
+		}
+	}
+}
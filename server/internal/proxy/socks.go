@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/ihaxolotl/webproxy/internal/data/chain"
+)
+
+// SOCKS protocol version 5, as specified by RFC 1928, and its username/password
+// authentication, as specified by RFC 1929.
+const (
+	socksVersion     = 0x05
+	socksAuthVersion = 0x01
+
+	socksAuthNone     = 0x00
+	socksAuthPassword = 0x02
+	socksAuthRejected = 0xff
+
+	socksCmdConnect = 0x01
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	socksSucceeded = 0x00
+)
+
+// socksReplies describes the failures reported by a SOCKS5 server.
+var socksReplies = map[byte]string{
+	0x01: "general server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// socksCommandNotSupported is the reply to commands other than CONNECT.
+const socksCommandNotSupported = 0x07
+
+var (
+	ErrSOCKSVersion = errors.New("socks: unsupported protocol version")
+	ErrSOCKSAuth    = errors.New("socks: no acceptable authentication method")
+	ErrSOCKSLogin   = errors.New("socks: username or password rejected")
+	ErrSOCKSCommand = errors.New("socks: only the CONNECT command is supported")
+)
+
+// HandleSOCKS handles a connection to a SOCKS5 listener. Clients may only ask
+// to connect, without authentication. Once the tunnel is accepted, its traffic
+// is intercepted if it is HTTP or TLS, and relayed otherwise.
+func (proxy *Proxy) HandleSOCKS(conn net.Conn) error {
+	addr, err := socksAccept(conn)
+	if err != nil {
+		return err
+	}
+
+	return proxy.handleTunnel(conn, addr)
+}
+
+// socksAccept performs the server side of a SOCKS5 handshake and returns the
+// address the client asked to connect to. The tunnel is reported as open before
+// the target is dialed, since the target is only dialed once the client's first
+// request is read.
+func socksAccept(conn net.Conn) (string, error) {
+	var (
+		header  [4]byte
+		methods []byte
+		addr    string
+		err     error
+	)
+
+	if _, err = io.ReadFull(conn, header[:2]); err != nil {
+		return "", err
+	}
+
+	if header[0] != socksVersion {
+		return "", ErrSOCKSVersion
+	}
+
+	methods = make([]byte, header[1])
+	if _, err = io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	if bytes.IndexByte(methods, socksAuthNone) < 0 {
+		conn.Write([]byte{socksVersion, socksAuthRejected})
+		return "", ErrSOCKSAuth
+	}
+
+	if _, err = conn.Write([]byte{socksVersion, socksAuthNone}); err != nil {
+		return "", err
+	}
+
+	if _, err = io.ReadFull(conn, header[:]); err != nil {
+		return "", err
+	}
+
+	if header[0] != socksVersion {
+		return "", ErrSOCKSVersion
+	}
+
+	if addr, err = readSOCKSAddr(conn, header[3]); err != nil {
+		return "", err
+	}
+
+	reply := []byte{socksVersion, socksSucceeded, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0}
+
+	if header[1] != socksCmdConnect {
+		reply[1] = socksCommandNotSupported
+		conn.Write(reply)
+
+		return "", ErrSOCKSCommand
+	}
+
+	if _, err = conn.Write(reply); err != nil {
+		return "", err
+	}
+
+	return addr, nil
+}
+
+// socksConnect asks the SOCKS5 server at the other end of conn to connect to
+// addr, authenticating with a username and password if one is given. The
+// hostname of addr is sent as it is, so that the server resolves it.
+func socksConnect(conn net.Conn, addr, username, password string) error {
+	var (
+		host  string
+		port  string
+		portn int
+		reply [4]byte
+		err   error
+	)
+
+	if host, port, err = net.SplitHostPort(addr); err != nil {
+		return err
+	}
+
+	if portn, err = strconv.Atoi(port); err != nil {
+		return err
+	}
+
+	methods := []byte{socksAuthNone}
+	if username != "" {
+		methods = append(methods, socksAuthPassword)
+	}
+
+	if _, err = conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	if _, err = io.ReadFull(conn, reply[:2]); err != nil {
+		return err
+	}
+
+	switch {
+	case reply[0] != socksVersion:
+		return ErrSOCKSVersion
+	case reply[1] == socksAuthPassword && username != "":
+		if err = socksLogin(conn, username, password); err != nil {
+			return err
+		}
+	case reply[1] != socksAuthNone:
+		return ErrSOCKSAuth
+	}
+
+	req := []byte{socksVersion, socksCmdConnect, 0x00}
+	req = appendSOCKSAddr(req, host)
+	req = append(req, byte(portn>>8), byte(portn))
+
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+
+	if _, err = io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+
+	if reply[0] != socksVersion {
+		return ErrSOCKSVersion
+	}
+
+	if reply[1] != socksSucceeded {
+		if desc, ok := socksReplies[reply[1]]; ok {
+			return fmt.Errorf("socks: %s", desc)
+		}
+
+		return fmt.Errorf("socks: unknown reply %#x", reply[1])
+	}
+
+	// The address the server bound is read and ignored.
+	_, err = readSOCKSAddr(conn, reply[3])
+
+	return err
+}
+
+// socksLogin authenticates with a username and password. Each is sent with a
+// one byte length, so longer credentials can't be sent.
+func socksLogin(conn net.Conn, username, password string) error {
+	var reply [2]byte
+
+	if len(username) > 255 || len(password) > 255 {
+		return chain.ErrLongCredentials
+	}
+
+	req := []byte{socksAuthVersion, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+
+	if reply[1] != socksSucceeded {
+		return ErrSOCKSLogin
+	}
+
+	return nil
+}
+
+// appendSOCKSAddr appends the type and the encoding of a host to a request.
+func appendSOCKSAddr(b []byte, host string) []byte {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return append(append(b, socksAddrIPv4), ip4...)
+		}
+
+		return append(append(b, socksAddrIPv6), ip.To16()...)
+	}
+
+	return append(append(b, socksAddrDomain, byte(len(host))), host...)
+}
+
+// readSOCKSAddr reads an address of the given type followed by a port, and
+// returns it as a host:port pair.
+func readSOCKSAddr(r io.Reader, atyp byte) (string, error) {
+	var (
+		host []byte
+		port [2]byte
+		err  error
+	)
+
+	switch atyp {
+	case socksAddrIPv4:
+		host = make([]byte, net.IPv4len)
+	case socksAddrIPv6:
+		host = make([]byte, net.IPv6len)
+	case socksAddrDomain:
+		var n [1]byte
+
+		if _, err = io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+
+		host = make([]byte, n[0])
+	default:
+		return "", fmt.Errorf("socks: unknown address type %#x", atyp)
+	}
+
+	if _, err = io.ReadFull(r, host); err != nil {
+		return "", err
+	}
+
+	if _, err = io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+
+	p := strconv.Itoa(int(port[0])<<8 | int(port[1]))
+
+	if atyp == socksAddrDomain {
+		return net.JoinHostPort(string(host), p), nil
+	}
+
+	return net.JoinHostPort(net.IP(host).String(), p), nil
+}
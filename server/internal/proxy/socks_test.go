@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSOCKSHandshake(t *testing.T) {
+	addrs := []string{"example.com:443", "127.0.0.1:8080", "[::1]:80"}
+
+	for _, addr := range addrs {
+		client, server := net.Pipe()
+
+		errs := make(chan error, 1)
+		go func() {
+			errs <- socksConnect(client, addr, "", "")
+			client.Close()
+		}()
+
+		accepted, err := socksAccept(server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.Close()
+
+		if err = <-errs; err != nil {
+			t.Fatal(err)
+		}
+
+		if accepted != addr {
+			t.Fatalf("fatal: %q expected, %q accepted.\n", addr, accepted)
+		}
+	}
+}
+
+func TestSOCKSRejectsLogin(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		// Only offer username/password authentication.
+		client.Write([]byte{socksVersion, 1, socksAuthPassword})
+		client.Read(make([]byte, 2))
+		client.Close()
+	}()
+
+	if _, err := socksAccept(server); err != ErrSOCKSAuth {
+		t.Fatalf("fatal: %v expected, %v returned.\n", ErrSOCKSAuth, err)
+	}
+}
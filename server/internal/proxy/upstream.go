@@ -0,0 +1,355 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/ihaxolotl/webproxy/internal/buffer"
+	"github.com/ihaxolotl/webproxy/internal/data/chain"
+	"github.com/ihaxolotl/webproxy/internal/data/requests"
+	"golang.org/x/net/http2"
+)
+
+const (
+	dialTimeout     = 30 * time.Second  // Time allowed to connect to a target server
+	responseTimeout = 120 * time.Second // Time allowed for a target server to respond
+)
+
+// handshakeError is returned by dial when the TLS handshake with the target
+// server fails.
+type handshakeError struct {
+	err error
+}
+
+func (e *handshakeError) Error() string {
+	return "tls handshake: " + e.err.Error()
+}
+
+func (e *handshakeError) Unwrap() error {
+	return e.err
+}
+
+// errorKinds describes each classification of upstream errors to the client.
+var errorKinds = map[string]string{
+	requests.ErrorKindDNS:     "the hostname could not be resolved",
+	requests.ErrorKindRefused: "the connection was refused",
+	requests.ErrorKindTimeout: "the server did not respond in time",
+	requests.ErrorKindTLS:     "the TLS handshake failed",
+	requests.ErrorKindReset:   "the connection was closed by the server",
+	requests.ErrorKindNetwork: "a network error occurred",
+	requests.ErrorKindProxy:   "the upstream proxy failed",
+}
+
+// classify returns the classification of an error that prevented the proxy
+// from exchanging a request with its target server.
+func classify(err error) string {
+	var (
+		dnsErr       *net.DNSError
+		netErr       net.Error
+		handshakeErr *handshakeError
+		proxyErr     *proxyError
+	)
+
+	switch {
+	case errors.As(err, &proxyErr):
+		return requests.ErrorKindProxy
+	case errors.As(err, &dnsErr):
+		return requests.ErrorKindDNS
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return requests.ErrorKindTimeout
+	case errors.As(err, &handshakeErr):
+		return requests.ErrorKindTLS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return requests.ErrorKindRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return requests.ErrorKindReset
+	}
+
+	return requests.ErrorKindNetwork
+}
+
+// upstream is a connection to a target server which is kept open so that it
+// can be reused for several requests.
+type upstream struct {
+	conn   net.Conn          // Connection to the target server
+	reader *buffer.Reader    // Response reader for the connection
+	first  *firstByteReader  // Records when the server starts to respond
+	ip     string            // Internet address the connection was dialed to
+	via    string            // Upstream proxy the connection goes through, if any
+	h2     *http2.ClientConn // HTTP/2 connection, if the target server negotiated it
+	dialed timing            // Time taken to open the connection
+}
+
+// timing is the breakdown of the time taken by an exchange with a target server.
+// The DNS, connect and TLS times are zero when a connection is reused.
+type timing struct {
+	DNS     time.Duration // Resolving the target's hostname
+	Connect time.Duration // Opening the TCP connection
+	TLS     time.Duration // Performing the TLS handshake
+	TTFB    time.Duration // Sending the request until the first response byte
+	Total   time.Duration // Connecting until the response was fully read
+}
+
+// firstByteReader records the time data is first read from a connection after
+// it was reset.
+type firstByteReader struct {
+	r  io.Reader
+	at time.Time
+}
+
+func (f *firstByteReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if n > 0 && f.at.IsZero() {
+		f.at = time.Now()
+	}
+
+	return n, err
+}
+
+// reset forgets the time data was last read.
+func (f *firstByteReader) reset() {
+	f.at = time.Time{}
+}
+
+// upstreams holds the connections to target servers opened on behalf of a
+// single client connection, keyed by scheme and host.
+type upstreams map[string]*upstream
+
+// targetAddr returns the host:port address of the target server of a request.
+func targetAddr(req *http.Request) string {
+	var port string
+
+	if port = req.URL.Port(); port != "" {
+		return req.URL.Host
+	}
+
+	port = "80"
+	if req.URL.Scheme == "https" {
+		port = "443"
+	}
+
+	return net.JoinHostPort(req.URL.Hostname(), port)
+}
+
+// upstreamKey returns the key of the upstream connection for a request.
+func upstreamKey(req *http.Request) string {
+	return req.URL.Scheme + "://" + targetAddr(req)
+}
+
+// dial connects to the target server of a request and measures how long each
+// step takes. The hostname is resolved first, and each of its addresses is tried
+// in turn. Requests with the https scheme are sent over TLS. A failed TLS
+// handshake is returned as a *handshakeError. HTTP/2 is offered to the target
+// server for requests received over HTTP/2.
+//
+// If an upstream proxy rule is given, the proxy is dialed instead and asked to
+// open a tunnel to the target server, which resolves the target's hostname. The
+// connect time then includes opening the tunnel, and failures up to that point
+// are returned as a *proxyError.
+func dial(req *http.Request, via *chain.Rule) (*upstream, error) {
+	var (
+		addr    string
+		host    string
+		port    string
+		ips     []net.IPAddr
+		conn    net.Conn
+		tlsConn *tls.Conn
+		start   time.Time
+		up      *upstream
+		err     error
+	)
+
+	if addr = targetAddr(req); via != nil {
+		addr = via.Address
+	}
+
+	if host, port, err = net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+
+	up = &upstream{via: proxyURL(via)}
+
+	start = time.Now()
+	if ips, err = net.DefaultResolver.LookupIPAddr(ctx, host); err != nil {
+		return nil, up.proxyError(err)
+	}
+	up.dialed.DNS = time.Since(start)
+
+	// A resolver may succeed without any addresses, leaving nothing to dial.
+	if len(ips) == 0 {
+		return nil, up.proxyError(&net.DNSError{Err: "no addresses", Name: host, IsNotFound: true})
+	}
+
+	start = time.Now()
+	for _, ip := range ips {
+		var dialer net.Dialer
+
+		if conn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port)); err == nil {
+			up.ip = ip.String()
+			break
+		}
+	}
+	if conn == nil {
+		return nil, up.proxyError(err)
+	}
+
+	if via != nil {
+		if conn, err = openTunnel(ctx, conn, targetAddr(req), via); err != nil {
+			return nil, up.proxyError(err)
+		}
+
+		// The address of the target server is only known to the proxy.
+		up.ip = ""
+	}
+	up.dialed.Connect = time.Since(start)
+
+	if req.URL.Scheme == "https" {
+		config := &tls.Config{
+			ServerName: req.URL.Hostname(),
+			// The proxy is a testing tool, so the target's certificate is
+			// deliberately not verified.
+			InsecureSkipVerify: true,
+		}
+
+		if req.ProtoMajor == 2 {
+			config.NextProtos = nextProtos
+		}
+
+		tlsConn = tls.Client(conn, config)
+
+		start = time.Now()
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, &handshakeError{err}
+		}
+		up.dialed.TLS = time.Since(start)
+
+		if tlsConn.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS {
+			if up.h2, err = h2Transport.NewClientConn(tlsConn); err != nil {
+				tlsConn.Close()
+				return nil, err
+			}
+		}
+
+		conn = tlsConn
+	}
+
+	up.conn = conn
+	up.first = &firstByteReader{r: conn}
+	up.reader = buffer.NewReader(up.first)
+
+	return up, nil
+}
+
+// proxyError wraps an error that occurred before the target server was reached
+// in a *proxyError, if the connection goes through an upstream proxy.
+func (up *upstream) proxyError(err error) error {
+	if up.via == "" {
+		return err
+	}
+
+	return &proxyError{up.via, err}
+}
+
+// openTunnel opens a tunnel to addr through the upstream proxy at the other end of
+// conn. The connection is closed if the proxy fails to open it.
+func openTunnel(ctx context.Context, conn net.Conn, addr string, via *chain.Rule) (net.Conn, error) {
+	var (
+		tunneled = conn
+		err      error
+	)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	switch via.Protocol {
+	case chain.ProtocolHTTP:
+		tunneled, err = httpConnect(conn, addr, via.Username, via.Password)
+	case chain.ProtocolSOCKS5:
+		err = socksConnect(conn, addr, via.Username, via.Password)
+	default:
+		err = fmt.Errorf("unknown protocol %q", via.Protocol)
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return tunneled, nil
+}
+
+// get returns an open connection to the target server of a request, or dials
+// a new one through the given upstream proxy. The returned flag reports whether
+// the connection was reused.
+func (u upstreams) get(req *http.Request, via *chain.Rule) (*upstream, bool, error) {
+	var (
+		key string
+		up  *upstream
+		err error
+	)
+
+	key = upstreamKey(req)
+	if up, ok := u[key]; ok {
+		return up, true, nil
+	}
+
+	if up, err = dial(req, via); err != nil {
+		return nil, false, err
+	}
+
+	u[key] = up
+
+	return up, false, nil
+}
+
+// discard closes the connection to the target server of a request, so that
+// the next request to it dials a new one. An HTTP/2 connection that can still
+// take requests is only forgotten, since the failure was that of a stream, and
+// other streams may be using the connection.
+func (u upstreams) discard(req *http.Request) {
+	key := upstreamKey(req)
+
+	if up, ok := u[key]; ok {
+		if up.h2 == nil || !up.h2.CanTakeNewRequest() {
+			up.conn.Close()
+		}
+
+		delete(u, key)
+	}
+}
+
+// take removes the connection to the target server of a request without
+// closing it, for connections switched to another protocol. It returns nil
+// if there is no such connection.
+func (u upstreams) take(req *http.Request) *upstream {
+	key := upstreamKey(req)
+
+	up := u[key]
+	delete(u, key)
+
+	return up
+}
+
+// close closes every connection to target servers.
+func (u upstreams) close() {
+	for key, up := range u {
+		up.conn.Close()
+		delete(u, key)
+	}
+}
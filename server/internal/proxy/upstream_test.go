@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ihaxolotl/webproxy/internal/data/requests"
+)
+
+func TestClassifyDialErrors(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	// Reserve a port and close it again, so nothing is listening on it.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := listener.Addr().String()
+	listener.Close()
+
+	targets := []struct {
+		url  string
+		kind string
+	}{
+		{"http://host.invalid/", requests.ErrorKindDNS},
+		{"http://" + closed + "/", requests.ErrorKindRefused},
+		{"https://" + server.Listener.Addr().String() + "/", requests.ErrorKindTLS},
+	}
+
+	for _, tc := range targets {
+		u, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		up, err := dial(&http.Request{URL: u}, nil)
+		if err == nil {
+			up.conn.Close()
+			t.Fatalf("fatal: %s: dial succeeded.\n", tc.url)
+		}
+
+		if kind := classify(err); kind != tc.kind {
+			t.Fatalf("fatal: %s: %q expected, %q returned: %v\n", tc.url, tc.kind, kind, err)
+		}
+	}
+}
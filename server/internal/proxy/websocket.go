@@ -0,0 +1,366 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ihaxolotl/webproxy/internal/buffer"
+	"github.com/ihaxolotl/webproxy/internal/data/messages"
+)
+
+// maxFramePayload is the largest WebSocket frame payload that is relayed.
+const maxFramePayload = 16 << 20
+
+// WebSocket frame opcodes.
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+	opClose        byte = 0x8
+	opPing         byte = 0x9
+	opPong         byte = 0xa
+)
+
+var opcodes = map[byte]string{
+	opContinuation: "continuation",
+	opText:         "text",
+	opBinary:       "binary",
+	opClose:        "close",
+	opPing:         "ping",
+	opPong:         "pong",
+}
+
+var ErrFrameTooLarge = errors.New("websocket frame payload too large")
+
+// frame is a WebSocket frame. The payload is kept unmasked, and masked again
+// with the same key when the frame is sent on.
+type frame struct {
+	fin     bool    // Whether the frame ends its message
+	rsv     byte    // Reserved bits, used by extensions
+	opcode  byte    // Type of the frame
+	masked  bool    // Whether the payload is masked, as it is by clients
+	key     [4]byte // Masking key of the payload
+	payload []byte  // Unmasked payload
+}
+
+// opcodeName returns the name of a frame's opcode.
+func (f *frame) opcodeName() string {
+	if name, ok := opcodes[f.opcode]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("0x%x", f.opcode)
+}
+
+// whole reports whether the frame carries a whole text or binary message, which
+// can be stalled. Control frames and the fragments of fragmented messages can't,
+// since dropping or resizing a fragment would break the sequence of its message.
+func (f *frame) whole() bool {
+	return f.fin && (f.opcode == opText || f.opcode == opBinary)
+}
+
+// readFrame reads a WebSocket frame from a connection.
+func readFrame(r io.Reader) (*frame, error) {
+	var (
+		head   [2]byte
+		ext    [8]byte
+		length uint64
+		f      *frame
+		err    error
+	)
+
+	if _, err = io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+
+	f = &frame{
+		fin:    head[0]&0x80 != 0,
+		rsv:    head[0] & 0x70,
+		opcode: head[0] & 0x0f,
+		masked: head[1]&0x80 != 0,
+	}
+
+	switch length = uint64(head[1] & 0x7f); length {
+	case 126:
+		if _, err = io.ReadFull(r, ext[:2]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:2]))
+	case 127:
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if length > maxFramePayload {
+		return nil, ErrFrameTooLarge
+	}
+
+	if f.masked {
+		if _, err = io.ReadFull(r, f.key[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	f.payload = make([]byte, length)
+	if _, err = io.ReadFull(r, f.payload); err != nil {
+		return nil, err
+	}
+
+	if f.masked {
+		mask(f.key, f.payload)
+	}
+
+	return f, nil
+}
+
+// bytes encodes the frame as it is sent on the wire.
+func (f *frame) bytes() []byte {
+	var (
+		b      []byte
+		head   byte
+		length = len(f.payload)
+	)
+
+	if head = f.rsv | f.opcode; f.fin {
+		head |= 0x80
+	}
+
+	b = make([]byte, 0, 14+length)
+	b = append(b, head)
+
+	var masked byte
+	if f.masked {
+		masked = 0x80
+	}
+
+	switch {
+	case length < 126:
+		b = append(b, masked|byte(length))
+	case length <= 0xffff:
+		b = append(b, masked|126, byte(length>>8), byte(length))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		b = append(b, masked|127)
+		b = append(b, ext[:]...)
+	}
+
+	if !f.masked {
+		return append(b, f.payload...)
+	}
+
+	b = append(b, f.key[:]...)
+	start := len(b)
+	b = append(b, f.payload...)
+	mask(f.key, b[start:])
+
+	return b
+}
+
+// mask masks or unmasks a payload in place.
+func mask(key [4]byte, payload []byte) {
+	for i := range payload {
+		payload[i] ^= key[i%4]
+	}
+}
+
+// isWebSocket reports whether a message asks to upgrade, or was upgraded, to
+// the WebSocket protocol.
+func isWebSocket(header http.Header) bool {
+	for _, v := range header.Values("Upgrade") {
+		if strings.Contains(strings.ToLower(v), "websocket") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// upgrade takes over a client connection that was switched to another protocol
+// by the last exchange, along with the connection to the target server, which
+// can't be reused for requests afterwards. WebSocket frames are relayed one by
+// one, so that they can be recorded and stalled. Other protocols are relayed as
+// they are. The reader is the one requests were read from the client with.
+func (proxy *Proxy) upgrade(conn net.Conn, reader *buffer.Reader, ups upstreams, d *httpdata) error {
+	var (
+		up     *upstream
+		client net.Conn
+		server net.Conn
+	)
+
+	if up = ups.take(d.Request); up == nil {
+		return nil
+	}
+	defer up.conn.Close()
+
+	client = &bufferedConn{conn, reader.Buffered()}
+	server = &bufferedConn{up.conn, up.reader.Buffered()}
+
+	if !isWebSocket(d.Response.Header) {
+		return pipe(client, server)
+	}
+
+	return proxy.relayWebSocket(client, server, d)
+}
+
+// relayWebSocket relays WebSocket frames between a client and a server in both
+// directions, until either side closes its connection.
+func (proxy *Proxy) relayWebSocket(client net.Conn, server net.Conn, d *httpdata) error {
+	var (
+		errc = make(chan error, 1)
+		err  error
+	)
+
+	go func() {
+		errc <- proxy.relayFrames(server, client, DirectionResponse, d)
+		client.Close()
+		server.Close()
+	}()
+
+	err = proxy.relayFrames(client, server, DirectionRequest, d)
+	client.Close()
+	server.Close()
+
+	if serr := <-errc; err == nil {
+		err = serr
+	}
+
+	return err
+}
+
+// relayFrames reads WebSocket frames from src and sends them to dst until src is
+// closed. Each frame is recorded as a message of the request that upgraded the
+// connection. Frames carrying whole messages are stalled if they match the
+// intercept rules, and may be edited or dropped, while fragmented messages are
+// passed through. A dropped frame is recorded but not sent, whatever the drop
+// action is, since a WebSocket connection has no response to answer it with.
+func (proxy *Proxy) relayFrames(src io.Reader, dst io.Writer, direction Direction, d *httpdata) error {
+	for {
+		var (
+			f       *frame
+			msg     messages.Message
+			payload []byte
+			err     error
+		)
+
+		if f, err = readFrame(src); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		msg = messages.Message{
+			ID:        uuid.New().String(),
+			ProjectID: proxy.projectId,
+			RequestID: d.RequestID,
+			Direction: messages.DirectionClient,
+			Opcode:    f.opcodeName(),
+			Final:     f.fin,
+			Timestamp: time.Now(),
+		}
+
+		if direction == DirectionResponse {
+			msg.Direction = messages.DirectionServer
+		}
+
+		// Frames without a payload have nothing to edit.
+		if f.whole() && len(f.payload) > 0 && proxy.interceptFrame(direction, d) {
+			payload, err = proxy.stallFrame(f.payload, direction, d.Request, msg.Opcode, &msg.Edited)
+			if err != nil && !errors.Is(err, ErrDropped) {
+				return err
+			}
+
+			msg.Dropped = err != nil
+
+			if msg.Edited {
+				msg.Original = f.payload
+				f.payload = payload
+			}
+		}
+
+		msg.Payload = f.payload
+		msg.Length = int64(len(f.payload))
+
+		// Passed through exchanges are not recorded, and neither are their frames.
+		if d.RequestID != "" {
+			if err = proxy.db.Messages.Insert(&msg); err != nil {
+				log.Println(err)
+			}
+		}
+
+		if msg.Dropped {
+			continue
+		}
+
+		if _, err = dst.Write(f.bytes()); err != nil {
+			return err
+		}
+	}
+}
+
+// interceptFrame reports whether the frames sent in a direction over a connection
+// upgraded by an exchange are stalled. Frames sent by the client are stalled if
+// the upgrade request matches the request rules, and frames sent by the server
+// if the upgrade response matches the response rules.
+func (proxy *Proxy) interceptFrame(direction Direction, d *httpdata) bool {
+	opts := proxy.options()
+
+	if !opts.Stall || d.Passthrough {
+		return false
+	}
+
+	if direction == DirectionRequest {
+		return opts.InterceptClient && proxy.ruleset().interceptRequest(d.Request)
+	}
+
+	return opts.InterceptServer && proxy.ruleset().interceptResponse(d.Request, d.Response)
+}
+
+// stallFrame stalls the payload of a WebSocket frame at the control panels like
+// stall does an HTTP message. The edited flag is set if the payload that is
+// forwarded differs, and a *dropError is returned if the frame is dropped.
+func (proxy *Proxy) stallFrame(
+	payload []byte,
+	direction Direction,
+	req *http.Request,
+	opcode string,
+	edited *bool,
+) ([]byte, error) {
+	var (
+		item *interception
+		cmd  ProxyCmd
+		ok   bool
+	)
+
+	item = proxy.queue.push(buffer.NewBufferFrom(payload, len(payload)), direction, req.URL.Host, opcode)
+
+	if cmd, ok = proxy.wait(item); !ok {
+		return payload, nil
+	}
+
+	if cmd.Type != ProxyCmdForward {
+		return payload, &dropError{cmd}
+	}
+
+	data, err := cmd.Payload()
+	if err != nil {
+		return nil, err
+	}
+
+	*edited = !bytes.Equal(data, payload)
+
+	return data, nil
+}
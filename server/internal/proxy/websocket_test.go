@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	frames := []*frame{
+		{fin: true, opcode: opText, payload: []byte("hello")},
+		{fin: true, opcode: opText, masked: true, key: [4]byte{1, 2, 3, 4}, payload: []byte("hello")},
+		{fin: false, opcode: opBinary, payload: bytes.Repeat([]byte{0xff}, 300)},
+		{fin: true, opcode: opContinuation, masked: true, key: [4]byte{9, 8, 7, 6}, payload: bytes.Repeat([]byte("a"), 70000)},
+		{fin: true, opcode: opClose, rsv: 0x40},
+	}
+
+	for _, f := range frames {
+		raw := f.bytes()
+
+		read, err := readFrame(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if read.fin != f.fin || read.rsv != f.rsv || read.opcode != f.opcode || read.masked != f.masked ||
+			read.key != f.key || !bytes.Equal(read.payload, f.payload) {
+			t.Fatalf("fatal: %s frame of %d bytes was not read back as it was written.\n", f.opcodeName(), len(f.payload))
+		}
+
+		if !bytes.Equal(read.bytes(), raw) {
+			t.Fatalf("fatal: %s frame of %d bytes was not encoded back as it was read.\n", f.opcodeName(), len(f.payload))
+		}
+	}
+}
+
+func TestReadMaskedFrame(t *testing.T) {
+	// Example of a masked text frame from RFC 6455, section 5.7.
+	raw := []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}
+
+	f, err := readFrame(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !f.fin || f.opcode != opText || string(f.payload) != "Hello" {
+		t.Fatalf("fatal: %q expected, %q returned.\n", "Hello", f.payload)
+	}
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	raw := []byte{0x82, 0x7f, 0, 0, 0, 0, 0x10, 0, 0, 0}
+
+	if _, err := readFrame(bytes.NewReader(raw)); err != ErrFrameTooLarge {
+		t.Fatalf("fatal: %v expected, %v returned.\n", ErrFrameTooLarge, err)
+	}
+}
+
+func TestFrameWhole(t *testing.T) {
+	frames := []struct {
+		f     *frame
+		whole bool
+	}{
+		{&frame{fin: true, opcode: opText}, true},
+		{&frame{fin: true, opcode: opBinary}, true},
+		{&frame{fin: false, opcode: opText}, false},
+		{&frame{fin: false, opcode: opContinuation}, false},
+		{&frame{fin: true, opcode: opContinuation}, false},
+		{&frame{fin: true, opcode: opPing}, false},
+	}
+
+	for _, tc := range frames {
+		if tc.f.whole() != tc.whole {
+			t.Fatalf("fatal: %s frame with fin %v: whole %v expected.\n", tc.f.opcodeName(), tc.f.fin, tc.whole)
+		}
+	}
+}
+
+func TestIsWebSocket(t *testing.T) {
+	header := http.Header{}
+	if isWebSocket(header) {
+		t.Fatalf("fatal: message without an Upgrade header is a WebSocket upgrade.\n")
+	}
+
+	header.Set("Upgrade", "WebSocket")
+	if !isWebSocket(header) {
+		t.Fatalf("fatal: %q is not a WebSocket upgrade.\n", header.Get("Upgrade"))
+	}
+}